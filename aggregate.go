@@ -0,0 +1,184 @@
+package statsd
+
+import (
+	"strconv"
+	"time"
+)
+
+// counterWindows sums unsampled counter updates per stat until the stat's
+// window of the configured interval has elapsed.
+type counterWindows struct {
+	interval time.Duration
+	pending  map[string]*counterWindow
+}
+
+type counterWindow struct {
+	sum   int
+	start time.Time
+}
+
+/**
+ * Aggregates unsampled counter updates: increments of a stat are summed
+ * and the sum is emitted at most once per interval, once the stat's window
+ * has elapsed, or on Flush and Close. A goroutine, stopped by Close,
+ * sends the sums of elapsed windows a few times per interval, so a sum
+ * does not wait for the stat's next update
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithCounterInterval(time.Second))
+ * for i := 0; i < 1000; i++ {
+ *     client.Increment("foo") // one foo:N|c per second
+ * }
+ **/
+func WithCounterInterval(interval time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.counters = &counterWindows{
+			interval: interval,
+			pending:  make(map[string]*counterWindow),
+		}
+		if interval > 0 {
+			client.background(client.flushCounters)
+		}
+	}
+}
+
+// flushCounters sends the sums of elapsed windows a few times per
+// interval, until quit is closed.
+func (client *StatsdClient) flushCounters(quit <-chan struct{}) {
+	tick := client.counters.interval / 4
+	if tick <= 0 {
+		tick = client.counters.interval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if ready := client.expiredCounters(); len(ready) > 0 {
+				client.Send(ready, 1)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// aggregate adds delta to every stat and returns the updates for the stats
+// whose window has elapsed.
+func (client *StatsdClient) aggregate(stats []string, delta int) map[string]string {
+	now := client.clock()
+	ready := make(map[string]string)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for _, stat := range stats {
+		window, ok := client.counters.pending[stat]
+		if !ok {
+			window = &counterWindow{start: now}
+			client.counters.pending[stat] = window
+		}
+		window.sum += delta
+		if now.Sub(window.start) >= client.counters.interval {
+			ready[stat] = strconv.Itoa(window.sum) + "|c"
+			delete(client.counters.pending, stat)
+		}
+	}
+	return ready
+}
+
+// expiredCounters returns the updates for the stats whose window has
+// elapsed, closing those windows.
+func (client *StatsdClient) expiredCounters() map[string]string {
+	now := client.clock()
+	ready := make(map[string]string)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for stat, window := range client.counters.pending {
+		if now.Sub(window.start) >= client.counters.interval {
+			ready[stat] = strconv.Itoa(window.sum) + "|c"
+			delete(client.counters.pending, stat)
+		}
+	}
+	return ready
+}
+
+// drainCounters returns the updates for every pending sum, closing all
+// windows.
+func (client *StatsdClient) drainCounters() map[string]string {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	ready := make(map[string]string, len(client.counters.pending))
+	for stat, window := range client.counters.pending {
+		ready[stat] = strconv.Itoa(window.sum) + "|c"
+	}
+	client.counters.pending = make(map[string]*counterWindow)
+	return ready
+}
+
+// maxRateStats bounds the number of stats WithCounterRates tracks.
+const maxRateStats = 10000
+
+// counterRates sums counter deltas per stat between flushes.
+type counterRates struct {
+	counts map[string]int
+	since  time.Time
+}
+
+/**
+ * Alongside every counter, gauges "<stat>.rate" on each Flush (and Close)
+ * to the counter's change per second since the previous flush, for
+ * dashboards that want rates rather than counts. Deltas are counted before
+ * sampling. Stats counted once keep being gauged, at 0 when idle; at most
+ * maxRateStats stats are tracked. Best combined with a periodic Flush
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithCounterRates())
+ * client.Increment("requests")
+ * client.Flush() // requests.rate:<requests per second>|g
+ **/
+func WithCounterRates() Option {
+	return func(client *StatsdClient) {
+		client.rates = &counterRates{counts: make(map[string]int)}
+	}
+}
+
+// countRates adds delta to the rate of every stat.
+func (client *StatsdClient) countRates(stats []string, delta int) {
+	now := client.clock()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	r := client.rates
+	if r.since.IsZero() {
+		r.since = now
+	}
+	for _, stat := range stats {
+		if _, ok := r.counts[stat]; ok || len(r.counts) < maxRateStats {
+			r.counts[stat] += delta
+		}
+	}
+}
+
+// flushRates gauges the rate of every tracked stat since the previous
+// flush and starts a new period.
+func (client *StatsdClient) flushRates() {
+	now := client.clock()
+	client.mu.Lock()
+	r := client.rates
+	elapsed := now.Sub(r.since).Seconds()
+	counts := make(map[string]int, len(r.counts))
+	for stat, count := range r.counts {
+		counts[stat] = count
+		r.counts[stat] = 0
+	}
+	started := !r.since.IsZero()
+	r.since = now
+	client.mu.Unlock()
+	if !started || elapsed <= 0 {
+		return
+	}
+	for _, stat := range sortedKeys(counts) {
+		client.GaugeFloat(client.join(stat, "rate"), float64(counts[stat])/elapsed)
+	}
+}
@@ -0,0 +1,53 @@
+package statsd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithCounterIntervalSumsAtTheBoundary(t *testing.T) {
+	clock := newFakeClock()
+	client, sink := newTestClient(withClock(clock), WithCounterInterval(time.Hour))
+	defer client.Close()
+	client.Increment("foo")
+	client.IncrementByValue("foo", 2)
+	clock.advance(30 * time.Minute)
+	client.Increment("foo")
+	expectLines(t, sink)
+	clock.advance(30 * time.Minute)
+	client.Increment("foo")
+	expectLines(t, sink, "foo:5|c")
+}
+
+func TestWithCounterIntervalFlushesIdleWindows(t *testing.T) {
+	clock := newFakeClock()
+	client, sink := newTestClient(withClock(clock), WithCounterInterval(4*time.Millisecond))
+	defer client.Close()
+	client.Increment("foo")
+	client.Increment("foo")
+	clock.advance(2 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	expectLines(t, sink)
+	clock.advance(2 * time.Millisecond)
+	eventually(t, func() bool { return len(sink.Lines()) == 1 })
+	expectLines(t, sink, "foo:2|c")
+}
+
+func TestWithCounterRates(t *testing.T) {
+	clock := newFakeClock()
+	client, sink := newTestClient(withClock(clock), WithCounterRates())
+	for i := 0; i < 6; i++ {
+		client.Increment("requests")
+	}
+	clock.advance(2 * time.Second)
+	client.Flush()
+	expectLines(t, sink, "requests:1|c", "requests:1|c", "requests:1|c",
+		"requests:1|c", "requests:1|c", "requests:1|c", "requests.rate:3|g")
+	sink.Reset()
+	client.IncrementByValue("requests", 2)
+	clock.advance(4 * time.Second)
+	client.Flush()
+	clock.advance(time.Second)
+	client.Flush()
+	expectLines(t, sink, "requests:2|c", "requests.rate:0.5|g", "requests.rate:0|g")
+}
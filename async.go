@@ -0,0 +1,195 @@
+package statsd
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errQueueFull = errors.New("statsd: best-effort queue full")
+	errStopped   = errors.New("statsd: best-effort writer stopped")
+)
+
+// asyncWriter hands datagrams to a background goroutine so that a slow
+// sink never blocks the caller; datagrams that do not fit in the queue are
+// dropped.
+type asyncWriter struct {
+	mu        sync.RWMutex // held for reading while sending on queue
+	closed    bool         // set under mu once stop has begun
+	queue     chan queued
+	done      chan struct{}
+	abort     chan struct{}
+	abandoned int64 // updates discarded after an aborted stop
+	inFlight  int64 // datagrams queued or being written
+}
+
+// queued is a datagram waiting for the worker, with when it was queued.
+type queued struct {
+	packet string
+	at     time.Time
+}
+
+/**
+ * Makes writes best-effort: datagrams are queued (up to queueSize) for a
+ * background goroutine and dropped, counted as "dropped" and "queue.full"
+ * in Snapshot, when the sink cannot keep up, instead of blocking the
+ * caller. SendWait still writes synchronously. Close writes out what is
+ * queued, see WithCloseTimeout; datagrams written during or after Close
+ * are dropped and counted as "dropped"
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.NewWithWriter(slowSink, statsd.WithBestEffort(1000))
+ **/
+func WithBestEffort(queueSize int) Option {
+	return func(client *StatsdClient) {
+		client.async = &asyncWriter{
+			queue: make(chan queued, queueSize),
+			done:  make(chan struct{}),
+			abort: make(chan struct{}),
+		}
+		go client.async.run(client)
+	}
+}
+
+/**
+ * In best-effort mode, drops datagrams that waited in the queue for longer
+ * than timeout instead of writing them, counting their updates as
+ * "dropped" and "expired" in Snapshot, so a slow write delays the
+ * datagrams behind it by at most timeout and the queue keeps moving
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.NewWithWriter(slowSink,
+ *     statsd.WithBestEffort(1000),
+ *     statsd.WithQueueTimeout(100*time.Millisecond))
+ **/
+func WithQueueTimeout(timeout time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.queueTimeout = timeout
+	}
+}
+
+/**
+ * In best-effort mode, makes a write to a full queue wait up to wait for
+ * room before the datagram is dropped, applying backpressure to callers
+ * instead of dropping at once. The queue size set by WithBestEffort stays
+ * the bound on datagrams in flight
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.NewWithWriter(slowSink,
+ *     statsd.WithBestEffort(100),
+ *     statsd.WithQueueBackpressure(5*time.Millisecond))
+ **/
+func WithQueueBackpressure(wait time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.queueWait = wait
+	}
+}
+
+/**
+ * Number of datagrams queued for, being written by or waiting for room in
+ * the queue of the best-effort writer. Beyond the queue size and the one
+ * datagram being written, only callers held back by WithQueueBackpressure
+ * add to it
+ **/
+func (client *StatsdClient) InFlight() int {
+	if client.async == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&client.async.inFlight))
+}
+
+func (a *asyncWriter) run(client *StatsdClient) {
+	defer close(a.done)
+	for item := range a.queue {
+		select {
+		case <-a.abort:
+			a.discard(client, item.packet)
+		default:
+			if client.queueTimeout > 0 && client.clock().Sub(item.at) > client.queueTimeout {
+				lines := lineCount(item.packet)
+				client.stats.add("expired", lines)
+				client.stats.add("dropped", lines)
+			} else {
+				client.writeNow(item.packet)
+			}
+		}
+		atomic.AddInt64(&a.inFlight, -1)
+	}
+}
+
+func (a *asyncWriter) enqueue(client *StatsdClient, packet string) error {
+	item := queued{packet: packet}
+	if client.queueTimeout > 0 {
+		item.at = client.clock()
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		client.stats.add("dropped", lineCount(packet))
+		return errStopped
+	}
+	atomic.AddInt64(&a.inFlight, 1)
+	select {
+	case a.queue <- item:
+		return nil
+	default:
+	}
+	if client.queueWait > 0 {
+		timer := time.NewTimer(client.queueWait)
+		defer timer.Stop()
+		select {
+		case a.queue <- item:
+			return nil
+		case <-timer.C:
+		}
+	}
+	atomic.AddInt64(&a.inFlight, -1)
+	client.stats.add("queue.full", 1)
+	client.stats.add("dropped", lineCount(packet))
+	return errQueueFull
+}
+
+// stop waits up to timeout (zero meaning no limit) for the queued datagrams
+// to be written, then discards the rest and returns how many updates they
+// held. Datagrams handed to enqueue once stop has begun are dropped.
+func (a *asyncWriter) stop(client *StatsdClient, timeout time.Duration) int {
+	a.mu.Lock()
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+	if timeout <= 0 {
+		<-a.done
+		return 0
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-a.done:
+		return 0
+	case <-timer.C:
+	}
+	close(a.abort)
+	for item := range a.queue {
+		a.discard(client, item.packet)
+		atomic.AddInt64(&a.inFlight, -1)
+	}
+	return int(atomic.LoadInt64(&a.abandoned))
+}
+
+func (a *asyncWriter) discard(client *StatsdClient, packet string) {
+	lines := lineCount(packet)
+	atomic.AddInt64(&a.abandoned, lines)
+	client.stats.add("dropped", lines)
+}
+
+func lineCount(packet string) int64 {
+	return int64(strings.Count(packet, "\n") + 1)
+}
@@ -0,0 +1,155 @@
+package statsd
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingWriter blocks every write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	sink    MemorySink
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.sink.Write(p)
+}
+
+func TestWithBestEffortDoesNotBlock(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	client := NewWithWriter(w, WithBestEffort(1))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			client.Increment("foo")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Increment blocked on a slow writer")
+	}
+	snapshot := client.Snapshot()
+	if snapshot["queue.full"] < 8 || snapshot["dropped"] != snapshot["queue.full"] {
+		t.Errorf("Snapshot() = %v, want 8 or 9 updates dropped", snapshot)
+	}
+	close(w.release)
+	client.Close()
+	if n := len(w.sink.Lines()) + int(snapshot["dropped"]); n != 10 {
+		t.Errorf("%d updates written or dropped, want 10", n)
+	}
+}
+
+func TestWithBestEffortWritesDuringClose(t *testing.T) {
+	client, sink := newTestClient(WithBestEffort(10), WithQueueBackpressure(time.Millisecond))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				client.Increment("foo")
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	client.Close()
+	wg.Wait()
+	client.Increment("foo")
+	snapshot := client.Snapshot()
+	if sent := int64(len(sink.Lines())); sent+snapshot["dropped"] != 1601 {
+		t.Errorf("%d sent and %d dropped, want 1601 in all", sent, snapshot["dropped"])
+	}
+	if snapshot["dropped"] == 0 {
+		t.Error("the write after Close was not counted as dropped")
+	}
+}
+
+func TestShutdownReturnsWithinTimeout(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	defer close(w.release)
+	client := NewWithWriter(w, WithBestEffort(10),
+		WithFlushPolicy(FlushPolicy{Count: 1, Interval: time.Millisecond}))
+	client.StartHeartbeat("alive", time.Millisecond)
+	for i := 0; i < 5; i++ {
+		client.Increment("foo")
+	}
+	start := time.Now()
+	dropped := client.Shutdown(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Shutdown took %v with a 50ms timeout", elapsed)
+	}
+	if dropped < 4 {
+		t.Errorf("Shutdown reported %d dropped, want the undrained updates", dropped)
+	}
+}
+
+func TestShutdownWaitsForSlowWorkers(t *testing.T) {
+	client, _ := newTestClient()
+	release := make(chan struct{})
+	client.background(func(quit <-chan struct{}) {
+		<-quit
+		<-release
+	})
+	start := time.Now()
+	client.Shutdown(20 * time.Millisecond)
+	close(release)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Shutdown waited %v for a stuck goroutine with a 20ms timeout", elapsed)
+	}
+}
+
+func TestWithQueueTimeout(t *testing.T) {
+	clock := newFakeClock()
+	w := &blockingWriter{release: make(chan struct{})}
+	client := NewWithWriter(w, withClock(clock), WithBestEffort(10), WithQueueTimeout(time.Second))
+	client.Increment("first")
+	eventually(t, func() bool { return client.InFlight() == 1 && len(client.async.queue) == 0 })
+	for i := 0; i < 4; i++ {
+		client.Increment("stale")
+	}
+	clock.advance(2 * time.Second)
+	client.Increment("fresh")
+	close(w.release)
+	client.Close()
+	expectLines(t, &w.sink, "first:1|c", "fresh:1|c")
+	if snapshot := client.Snapshot(); snapshot["expired"] != 4 || snapshot["dropped"] != 4 {
+		t.Errorf("Snapshot() = %v, want 4 expired", snapshot)
+	}
+}
+
+func TestInFlightIsBounded(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	client := NewWithWriter(w, WithBestEffort(4), WithQueueBackpressure(time.Millisecond))
+	client.Increment("foo")
+	eventually(t, func() bool { return len(client.async.queue) == 0 })
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				client.Increment("foo")
+				if n := client.InFlight(); n > 4+1+4 {
+					t.Errorf("InFlight() = %d, want at most 9", n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := client.InFlight(); n != 5 {
+		t.Errorf("InFlight() = %d with a stuck writer, want 5", n)
+	}
+	snapshot := client.Snapshot()
+	if snapshot["queue.full"] != 36 || snapshot["dropped"] != 36 {
+		t.Errorf("Snapshot() = %v, want 36 dropped", snapshot)
+	}
+	close(w.release)
+	client.Close()
+	if n := client.InFlight(); n != 0 {
+		t.Errorf("InFlight() = %d after Close, want 0", n)
+	}
+}
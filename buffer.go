@@ -0,0 +1,389 @@
+package statsd
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxPacketSize is the largest datagram written in buffered mode, chosen to
+// fit a typical 1500 byte MTU after IP and UDP headers.
+const MaxPacketSize = 1432
+
+// buffer holds formatted updates until they are flushed as newline
+// delimited datagrams.
+type buffer struct {
+	lines     []string
+	sets      map[string]map[string]bool
+	setValues int // distinct values in sets
+	policy    FlushPolicy
+	bytes     int       // size of lines and set values once newline delimited
+	since     time.Time // when the oldest buffered line or set value was added
+}
+
+// add accounts for the update of size bytes just buffered at now and
+// reports whether the flush policy is due. Callers hold mu.
+func (b *buffer) add(size int, now time.Time) bool {
+	if len(b.lines)+b.setValues == 1 {
+		b.since = now
+	} else {
+		b.bytes++
+	}
+	b.bytes += size
+	return b.policy.due(len(b.lines)+b.setValues, b.bytes, now.Sub(b.since))
+}
+
+/**
+ * When buffered updates are flushed: as soon as Count updates or Bytes
+ * bytes are buffered, or the oldest buffered update is Interval old,
+ * whichever comes first. Each distinct buffered set value counts as one
+ * update. A zero field disables its trigger; Flush and
+ * Close always flush
+ **/
+type FlushPolicy struct {
+	Count    int
+	Bytes    int
+	Interval time.Duration
+}
+
+// due reports whether a buffer of count lines and bytes bytes, the oldest
+// of them age old, must be flushed.
+func (policy FlushPolicy) due(count int, bytes int, age time.Duration) bool {
+	if count == 0 {
+		return false
+	}
+	return (policy.Count > 0 && count >= policy.Count) ||
+		(policy.Bytes > 0 && bytes >= policy.Bytes) ||
+		(policy.Interval > 0 && age >= policy.Interval)
+}
+
+/**
+ * Enables buffered mode: updates of any type are held in memory and
+ * written as newline delimited datagrams (of at most MaxPacketSize bytes)
+ * once flushCount updates are buffered, on Flush, or on Close. Set values
+ * are deduplicated until the next flush, so each distinct value of a set is
+ * only sent once per flush. Updates keep the order they were made in (the
+ * stats of one Send call in name order), followed by set values sorted by
+ * stat and value
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithBuffering(100))
+ * defer client.Close()
+ * client.Increment("foo")
+ * client.Timing("bar", 12)
+ **/
+func WithBuffering(flushCount int) Option {
+	if flushCount < 1 {
+		flushCount = 1
+	}
+	return WithFlushPolicy(FlushPolicy{Count: flushCount})
+}
+
+/**
+ * Enables buffered mode, like WithBuffering, flushing on whichever trigger
+ * of policy fires first. With an Interval a goroutine, stopped by Close,
+ * checks the age of the buffer a few times per interval
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithFlushPolicy(statsd.FlushPolicy{
+ *     Count:    100,
+ *     Bytes:    statsd.MaxPacketSize,
+ *     Interval: time.Second,
+ * }))
+ * defer client.Close()
+ **/
+func WithFlushPolicy(policy FlushPolicy) Option {
+	return func(client *StatsdClient) {
+		client.buffer = &buffer{policy: policy}
+		if policy.Interval > 0 {
+			client.background(client.flushEvery)
+		}
+	}
+}
+
+/**
+ * Registers fn to be called after every flush of the buffer (by Flush,
+ * Close or the flush count) with the number of updates and bytes written.
+ * Only meaningful together with WithBuffering
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithBuffering(100),
+ *     statsd.WithOnFlush(func(metrics int, bytes int) {
+ *         log.Printf("flushed %d metrics in %d bytes", metrics, bytes)
+ *     }))
+ **/
+func WithOnFlush(fn func(metrics int, bytes int)) Option {
+	return func(client *StatsdClient) {
+		client.onFlush = fn
+	}
+}
+
+/**
+ * Flushes buffered and aggregated state, as Flush does, whenever the Go
+ * heap in use exceeds heapBytes, as a safety valve capping the client's
+ * memory footprint. A goroutine, stopped by Close, reads the runtime
+ * memory statistics every interval; a non-positive interval disables the
+ * option
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithBuffering(1000),
+ *     statsd.WithMemoryFlush(512<<20, time.Second))
+ **/
+func WithMemoryFlush(heapBytes uint64, interval time.Duration) Option {
+	return func(client *StatsdClient) {
+		if interval <= 0 {
+			return
+		}
+		read := client.readMemStats
+		if read == nil {
+			read = runtime.ReadMemStats
+		}
+		client.background(func(quit <-chan struct{}) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			var m runtime.MemStats
+			for {
+				select {
+				case <-ticker.C:
+					if read(&m); m.HeapAlloc > heapBytes {
+						client.Flush()
+					}
+				case <-quit:
+					return
+				}
+			}
+		})
+	}
+}
+
+/**
+ * Caps the number of updates packed into one datagram in buffered mode,
+ * in addition to the MaxPacketSize byte cap, for daemons that rate-limit
+ * by datagram
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithBuffering(100),
+ *     statsd.WithMaxLinesPerPacket(20))
+ **/
+func WithMaxLinesPerPacket(n int) Option {
+	return func(client *StatsdClient) {
+		client.maxLines = n
+	}
+}
+
+/**
+ * Without buffering, packs the updates of one Send (or UpdateStats, ...)
+ * call into as few newline delimited datagrams as fit MaxPacketSize and
+ * WithMaxLinesPerPacket, instead of writing one datagram per update, so a
+ * large map is split into bounded datagrams
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithPacking())
+ * client.Send(manyStats, 1) // one datagram per ~1.4kB of updates
+ **/
+func WithPacking() Option {
+	return func(client *StatsdClient) {
+		client.packing = true
+	}
+}
+
+/**
+ * Writes all buffered updates, the sums of counters aggregated with
+ * WithCounterInterval, the rates of WithCounterRates, the start time of
+ * WithStartEpoch, the timings kept by WithTimerReservoir and the
+ * percentiles of WithTimerPercentiles. A no-op when none of them is
+ * enabled
+ **/
+func (client *StatsdClient) Flush() {
+	if client.counters != nil {
+		client.Send(client.drainCounters(), 1)
+	}
+	if client.rates != nil {
+		client.flushRates()
+	}
+	if client.started != nil {
+		client.gaugeStart()
+	}
+	if client.reservoirs != nil {
+		lines := client.reservoirs.drain()
+		for i, line := range lines {
+			lines[i] = client.sequenced(line)
+		}
+		client.dispatch(lines)
+	}
+	if client.digests != nil {
+		client.flushDigests()
+	}
+	if client.buffer == nil {
+		return
+	}
+	client.flushBuffer()
+}
+
+/**
+ * Returns a copy of the updates currently buffered, in the order they will
+ * be sent, without flushing or otherwise changing the buffer. Set values
+ * and WithCounterInterval sums are only rendered at flush time and are not
+ * included. Returns nil when buffering is disabled
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithBuffering(100))
+ * client.Increment("foo")
+ * client.PeekBuffer() // ["foo:1|c"]
+ **/
+func (client *StatsdClient) PeekBuffer() []string {
+	if client.buffer == nil {
+		return nil
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return append([]string(nil), client.buffer.lines...)
+}
+
+// enqueue buffers one update, flushing once the flush policy is due.
+func (client *StatsdClient) enqueue(line string) {
+	now := client.clock()
+	client.mu.Lock()
+	b := client.buffer
+	b.lines = append(b.lines, line)
+	due := b.add(len(line), now)
+	client.mu.Unlock()
+	if due {
+		client.flushBuffer()
+	}
+}
+
+// flushEvery flushes the buffer once its oldest line is older than the
+// policy interval, until quit is closed.
+func (client *StatsdClient) flushEvery(quit <-chan struct{}) {
+	tick := client.buffer.policy.Interval / 4
+	if tick <= 0 {
+		tick = client.buffer.policy.Interval
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := client.clock()
+			client.mu.Lock()
+			b := client.buffer
+			due := b.policy.due(len(b.lines)+b.setValues, b.bytes, now.Sub(b.since))
+			client.mu.Unlock()
+			if due {
+				client.flushBuffer()
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// addSet records a set value until the next flush. Each distinct value
+// counts towards the flush policy as one update, of the size of its line
+// before prefixes and tags, which are only added at flush time.
+func (client *StatsdClient) addSet(stat string, value string) {
+	now := client.clock()
+	client.mu.Lock()
+	b := client.buffer
+	if b.sets == nil {
+		b.sets = make(map[string]map[string]bool)
+	}
+	if b.sets[stat] == nil {
+		b.sets[stat] = make(map[string]bool)
+	}
+	if b.sets[stat][value] {
+		client.mu.Unlock()
+		return
+	}
+	b.sets[stat][value] = true
+	b.setValues++
+	due := b.add(len(stat)+1+len(value)+len("|s"), now)
+	client.mu.Unlock()
+	if due {
+		client.flushBuffer()
+	}
+}
+
+// takeBuffer empties the buffer, returning its lines followed by one line
+// per distinct set value.
+func (client *StatsdClient) takeBuffer() []string {
+	client.mu.Lock()
+	lines, sets := client.buffer.lines, client.buffer.sets
+	client.buffer.lines, client.buffer.sets = nil, nil
+	client.buffer.setValues, client.buffer.bytes = 0, 0
+	client.mu.Unlock()
+	for _, stat := range sortedKeys(sets) {
+		for _, value := range sortedKeys(sets[stat]) {
+			lines = append(lines, client.format(map[string]string{stat: value + "|s"}, 1)...)
+		}
+	}
+	return lines
+}
+
+// flushBuffer writes out the buffer and reports the flush to the hook.
+func (client *StatsdClient) flushBuffer() {
+	lines := client.takeBuffer()
+	bytes := client.flushLines(lines)
+	if client.onFlush != nil {
+		client.onFlush(len(lines), bytes)
+	}
+}
+
+// flushLines packs lines into datagrams no larger than MaxPacketSize,
+// including the packet header, and of at most WithMaxLinesPerPacket lines,
+// and returns the number of bytes written. A line that alone exceeds the
+// limit is sent in its own datagram.
+func (client *StatsdClient) flushLines(lines []string) int {
+	limit := MaxPacketSize - len(client.header)
+	bytes := 0
+	count := 0
+	var packet strings.Builder
+	for _, line := range lines {
+		if packet.Len() > 0 && (packet.Len()+1+len(line) > limit ||
+			client.maxLines > 0 && count >= client.maxLines) {
+			bytes += client.writeCounted(packet.String())
+			packet.Reset()
+			count = 0
+		}
+		count++
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		bytes += client.writeCounted(packet.String())
+	}
+	return bytes
+}
+
+// writeCounted writes a datagram, returning its size or 0 if it failed.
+func (client *StatsdClient) writeCounted(packet string) int {
+	if client.write(packet) != nil {
+		return 0
+	}
+	return len(client.datagram(packet))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
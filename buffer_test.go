@@ -0,0 +1,294 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithMemoryFlush(t *testing.T) {
+	var heap uint64
+	readMemStats := func(client *StatsdClient) {
+		client.readMemStats = func(m *runtime.MemStats) {
+			m.HeapAlloc = atomic.LoadUint64(&heap)
+		}
+	}
+	client, sink := newTestClient(WithBuffering(100), readMemStats,
+		WithMemoryFlush(1000, time.Millisecond))
+	defer client.Close()
+	atomic.StoreUint64(&heap, 999)
+	client.Increment("foo")
+	time.Sleep(20 * time.Millisecond)
+	if lines := sink.Lines(); len(lines) != 0 {
+		t.Fatalf("flushed below the threshold: %q", lines)
+	}
+	atomic.StoreUint64(&heap, 1001)
+	eventually(t, func() bool { return len(sink.Lines()) == 1 })
+	expectLines(t, sink, "foo:1|c")
+}
+
+func TestWithBufferingFlushesAtTotalCount(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithBuffering(4))
+	client.Increment("foo")
+	client.Set("users", "alice")
+	client.Gauge("bar", 2)
+	client.Set("users", "alice")
+	if got := sink.written(); len(got) != 0 {
+		t.Fatalf("flushed before the count: %q", got)
+	}
+	client.Timing("baz", 5)
+	client.Increment("qux")
+	want := []string{"foo:1|c\nbar:2|g\nbaz:5|ms\nusers:alice|s"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+	client.Close()
+	if got := sink.written(); len(got) != 2 || got[1] != "qux:1|c" {
+		t.Fatalf("Close did not flush the rest: %q", got)
+	}
+}
+
+func TestBufferedSetsAreDeduplicated(t *testing.T) {
+	client, sink := newTestClient(WithBuffering(100))
+	client.Set("users", "alice")
+	client.Set("users", "bob")
+	client.Set("users", "alice")
+	client.Set("users", "alice")
+	client.Set("hosts", "a")
+	client.Flush()
+	expectLines(t, sink, "hosts:a|s", "users:alice|s", "users:bob|s")
+	sink.Reset()
+	client.Set("users", "alice")
+	client.Flush()
+	expectLines(t, sink, "users:alice|s")
+}
+
+func TestPeekBuffer(t *testing.T) {
+	client, sink := newTestClient(WithBuffering(100))
+	client.Increment("foo")
+	client.Gauge("bar", 2)
+	peeked := client.PeekBuffer()
+	if want := []string{"foo:1|c", "bar:2|g"}; !reflect.DeepEqual(peeked, want) {
+		t.Fatalf("PeekBuffer = %q, want %q", peeked, want)
+	}
+	peeked[0] = "changed"
+	expectLines(t, sink)
+	client.Flush()
+	expectLines(t, sink, "foo:1|c", "bar:2|g")
+	if peeked := client.PeekBuffer(); len(peeked) != 0 {
+		t.Fatalf("PeekBuffer after Flush = %q", peeked)
+	}
+}
+
+func TestBufferMixesTypesInOneDatagram(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithBuffering(100), WithPrefix("app"))
+	client.Increment("requests")
+	client.Gauge("queue", 7)
+	client.TimingAuto("latency", 1500*time.Microsecond)
+	client.Flush()
+	want := []string{"app.requests:1|c\napp.queue:7|g\napp.latency:1.5|ms"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+}
+
+func TestWithOnFlush(t *testing.T) {
+	type flush struct{ metrics, bytes int }
+	var flushes []flush
+	client, _ := newTestClient(WithBuffering(3), WithOnFlush(func(metrics int, bytes int) {
+		flushes = append(flushes, flush{metrics, bytes})
+	}))
+	client.Increment("foo")
+	client.Increment("bar")
+	client.Increment("baz")
+	client.Gauge("qux", 10)
+	client.Close()
+	want := []flush{{3, len("foo:1|c\nbar:1|c\nbaz:1|c")}, {1, len("qux:10|g")}}
+	if !reflect.DeepEqual(flushes, want) {
+		t.Fatalf("flushes = %v, want %v", flushes, want)
+	}
+}
+
+func TestBufferOrderIsStable(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithBuffering(100))
+	client.Increment("zeta")
+	client.Send(map[string]string{"delta": "1|c", "alpha": "2|g", "charlie": "3|ms", "bravo": "4|c"}, 1)
+	client.Set("users", "bob")
+	client.Set("users", "alice")
+	client.Increment("beta")
+	client.Flush()
+	want := []string{"zeta:1|c\nalpha:2|g\nbravo:4|c\ncharlie:3|ms\ndelta:1|c\nbeta:1|c\nusers:alice|s\nusers:bob|s"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+}
+
+func TestFlushPolicyTriggers(t *testing.T) {
+	policy := FlushPolicy{Count: 3, Bytes: 20, Interval: time.Second}
+	for _, tt := range []struct {
+		name         string
+		count, bytes int
+		age          time.Duration
+		due          bool
+	}{
+		{"empty", 0, 0, time.Hour, false},
+		{"none", 2, 19, time.Second - 1, false},
+		{"count", 3, 19, 0, true},
+		{"bytes", 1, 20, 0, true},
+		{"interval", 1, 1, time.Second, true},
+	} {
+		if due := policy.due(tt.count, tt.bytes, tt.age); due != tt.due {
+			t.Errorf("%s: due = %v, want %v", tt.name, due, tt.due)
+		}
+	}
+}
+
+func TestFlushPolicyBytesWins(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithFlushPolicy(FlushPolicy{Count: 100, Bytes: 30, Interval: time.Hour}))
+	defer client.Close()
+	client.Increment("foo.bar")
+	client.Increment("foo.baz")
+	if got := sink.written(); len(got) != 0 {
+		t.Fatalf("flushed %q at 23 bytes", got)
+	}
+	client.Increment("foo.qux")
+	if got := sink.written(); len(got) != 1 || got[0] != "foo.bar:1|c\nfoo.baz:1|c\nfoo.qux:1|c" {
+		t.Fatalf("datagrams = %q, want one flush at 35 bytes", got)
+	}
+}
+
+func TestFlushPolicyCountWins(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithFlushPolicy(FlushPolicy{Count: 2, Bytes: MaxPacketSize, Interval: time.Hour}))
+	defer client.Close()
+	client.Increment("a")
+	client.Increment("b")
+	if got := sink.written(); len(got) != 1 || got[0] != "a:1|c\nb:1|c" {
+		t.Fatalf("datagrams = %q, want one flush at 2 updates", got)
+	}
+}
+
+func TestFlushPolicyIntervalWins(t *testing.T) {
+	clock := newFakeClock()
+	sink := &datagrams{}
+	client := NewWithWriter(sink, withClock(clock),
+		WithFlushPolicy(FlushPolicy{Count: 100, Bytes: MaxPacketSize, Interval: 4 * time.Millisecond}))
+	defer client.Close()
+	client.Increment("a")
+	time.Sleep(20 * time.Millisecond)
+	if got := sink.written(); len(got) != 0 {
+		t.Fatalf("flushed %q before the buffer aged", got)
+	}
+	clock.advance(4 * time.Millisecond)
+	eventually(t, func() bool { return len(sink.written()) == 1 })
+	if got := sink.written(); got[0] != "a:1|c" {
+		t.Fatalf("datagrams = %q", got)
+	}
+}
+
+func TestWithMaxLinesPerPacket(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithBuffering(100), WithMaxLinesPerPacket(3))
+	for i := 0; i < 10; i++ {
+		client.Increment("a")
+		client.Gauge("b", i)
+	}
+	client.Flush()
+	packets := sink.written()
+	lines := 0
+	for _, packet := range packets {
+		n := len(strings.Split(packet, "\n"))
+		if n > 3 {
+			t.Errorf("datagram %q has %d lines, want at most 3", packet, n)
+		}
+		lines += n
+	}
+	if len(packets) != 7 || lines != 20 {
+		t.Errorf("datagrams = %q, want 20 lines in 7 datagrams", packets)
+	}
+}
+
+func TestWithPackingSplitsLargeSends(t *testing.T) {
+	data := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		data[fmt.Sprintf("metric.%03d", i)] = "1|c"
+	}
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithPacking())
+	client.Send(data, 1)
+	packets := sink.written()
+	lines := 0
+	for _, packet := range packets {
+		if len(packet) > MaxPacketSize {
+			t.Errorf("datagram of %d bytes, want at most %d", len(packet), MaxPacketSize)
+		}
+		lines += len(strings.Split(packet, "\n"))
+	}
+	if len(packets) != 3 || lines != 200 {
+		t.Errorf("%d updates in %d datagrams, want 200 in 3", lines, len(packets))
+	}
+
+	sink = &datagrams{}
+	client = NewWithWriter(sink)
+	client.Send(data, 1)
+	if n := len(sink.written()); n != 200 {
+		t.Errorf("%d datagrams without WithPacking, want 200", n)
+	}
+}
+
+func TestBufferedSetsTriggerFlushPolicy(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithBuffering(3))
+	client.Set("users", "alice")
+	client.Set("users", "alice")
+	client.Set("users", "bob")
+	if got := sink.written(); len(got) != 0 {
+		t.Fatalf("flushed %q before 3 distinct values", got)
+	}
+	client.Set("hosts", "a")
+	want := []string{"hosts:a|s\nusers:alice|s\nusers:bob|s"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+
+	sink = &datagrams{}
+	client = NewWithWriter(sink, WithFlushPolicy(FlushPolicy{Bytes: 20}))
+	client.Set("users", "alice")
+	client.Set("users", "bob")
+	if got := sink.written(); len(got) != 1 || got[0] != "users:alice|s\nusers:bob|s" {
+		t.Fatalf("datagrams = %q, want one flush at 26 bytes", got)
+	}
+}
+
+func TestBufferedSetsTriggerFlushInterval(t *testing.T) {
+	clock := newFakeClock()
+	sink := &datagrams{}
+	client := NewWithWriter(sink, withClock(clock),
+		WithFlushPolicy(FlushPolicy{Interval: 4 * time.Millisecond}))
+	defer client.Close()
+	client.Set("users", "alice")
+	clock.advance(4 * time.Millisecond)
+	eventually(t, func() bool { return len(sink.written()) == 1 })
+	if got := sink.written(); got[0] != "users:alice|s" {
+		t.Fatalf("datagrams = %q", got)
+	}
+}
+
+func TestWithMemoryFlushZeroInterval(t *testing.T) {
+	client, sink := newTestClient(WithBuffering(100), WithMemoryFlush(0, 0))
+	client.Increment("foo")
+	time.Sleep(10 * time.Millisecond)
+	if lines := sink.Lines(); len(lines) != 0 {
+		t.Fatalf("flushed with a zero interval: %q", lines)
+	}
+	client.Close()
+	expectLines(t, sink, "foo:1|c")
+}
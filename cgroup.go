@@ -0,0 +1,80 @@
+package statsd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Gauges the CPU limit (in cores) and memory limit (in bytes) of the
+ * container the process runs in as "<stat>.cpu" and "<stat>.memory", read
+ * from cgroup v2 or, failing that, cgroup v1. Meant to be called once at
+ * startup so dashboards can relate usage to limits. A limit that is not
+ * set or cannot be read is not gauged, so outside a container this is a
+ * no-op
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.GaugeContainerLimits("container.limit")
+ **/
+func (client *StatsdClient) GaugeContainerLimits(stat string) {
+	read := client.readFile
+	if read == nil {
+		read = os.ReadFile
+	}
+	if cores, ok := cgroupCPU(read); ok {
+		client.GaugeFloat(client.join(stat, "cpu"), cores)
+	}
+	if bytes, ok := cgroupMemory(read); ok {
+		client.GaugeFloat(client.join(stat, "memory"), float64(bytes))
+	}
+}
+
+// cgroupCPU returns the CPU quota in cores from cpu.max (v2) or
+// cpu.cfs_quota_us and cpu.cfs_period_us (v1).
+func cgroupCPU(read func(string) ([]byte, error)) (float64, bool) {
+	if data, err := read("/sys/fs/cgroup/cpu.max"); err == nil {
+		quota, period, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+		return cpuQuota(quota, period)
+	}
+	quota, err := read("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	if err != nil {
+		return 0, false
+	}
+	period, err := read("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err != nil {
+		return 0, false
+	}
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func cpuQuota(quota string, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false // "max" or -1: no limit
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+// cgroupMemory returns the memory limit in bytes from memory.max (v2) or
+// memory.limit_in_bytes (v1). cgroup v1 reports no limit as a value close
+// to the maximum int64, which is ignored.
+func cgroupMemory(read func(string) ([]byte, error)) (int64, bool) {
+	data, err := read("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		if data, err = read("/sys/fs/cgroup/memory/memory.limit_in_bytes"); err != nil {
+			return 0, false
+		}
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || limit <= 0 || limit >= 1<<62 {
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,53 @@
+package statsd
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// withFiles makes the client read files from a fixed set of contents.
+func withFiles(files map[string]string) Option {
+	return func(client *StatsdClient) {
+		client.readFile = func(name string) ([]byte, error) {
+			if data, ok := files[name]; ok {
+				return []byte(data), nil
+			}
+			return nil, os.ErrNotExist
+		}
+	}
+}
+
+func TestGaugeContainerLimits(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{"v2", map[string]string{
+			"/sys/fs/cgroup/cpu.max":    "150000 100000\n",
+			"/sys/fs/cgroup/memory.max": "536870912\n",
+		}, []string{"container.limit.cpu:1.5|g", "container.limit.memory:536870912|g"}},
+		{"v2 unlimited", map[string]string{
+			"/sys/fs/cgroup/cpu.max":    "max 100000\n",
+			"/sys/fs/cgroup/memory.max": "max\n",
+		}, nil},
+		{"v1", map[string]string{
+			"/sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "200000\n",
+			"/sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+			"/sys/fs/cgroup/memory/memory.limit_in_bytes": "1073741824\n",
+		}, []string{"container.limit.cpu:2|g", "container.limit.memory:1073741824|g"}},
+		{"v1 unlimited", map[string]string{
+			"/sys/fs/cgroup/cpu/cpu.cfs_quota_us":         "-1\n",
+			"/sys/fs/cgroup/cpu/cpu.cfs_period_us":        "100000\n",
+			"/sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		}, nil},
+		{"no cgroup", nil, nil},
+	} {
+		client, sink := newTestClient(withFiles(tt.files))
+		client.GaugeContainerLimits("container.limit")
+		if got := sink.Lines(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: lines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package statsd
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestOpenSetsCloseOnExec(t *testing.T) {
+	target := listen(t)
+	client := New("127.0.0.1", target.LocalAddr().(*net.UDPAddr).Port)
+	defer client.Close()
+	conn, ok := client.conn.(syscall.Conn)
+	if !ok {
+		t.Fatalf("connection %T has no file descriptor", client.conn)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+	var flags uintptr
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		flags, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFD, 0)
+	})
+	if err != nil || errno != 0 {
+		t.Fatalf("fcntl(F_GETFD): %v %v", err, errno)
+	}
+	if flags&syscall.FD_CLOEXEC == 0 {
+		t.Fatal("FD_CLOEXEC is not set on the connection")
+	}
+}
@@ -0,0 +1,69 @@
+package statsd
+
+import "time"
+
+/**
+ * The effective settings of a client, as returned by Config
+ **/
+type Config struct {
+	Host            string
+	Port            int
+	Transport       string // "udp", "unix", "packetconn" or "writer"
+	Prefix          string
+	TypePrefixes    map[string]string
+	Separator       string
+	Tags            []string
+	MaxTags         int
+	Buffered        bool
+	FlushCount      int // updates that trigger a flush in buffered mode
+	FlushPolicy     FlushPolicy
+	BestEffortQueue int // queue size in best-effort mode, 0 when disabled
+	RefreshInterval time.Duration
+	CloseTimeout    time.Duration
+}
+
+/**
+ * Returns a copy of the client's effective configuration, after defaults
+ * and options are applied
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithPrefix("app"))
+ * client.Config().Prefix // "app"
+ **/
+func (client *StatsdClient) Config() Config {
+	config := Config{
+		Host:            client.Host,
+		Port:            client.Port,
+		Transport:       "udp",
+		Prefix:          client.prefix,
+		Separator:       client.separator(),
+		Tags:            append([]string(nil), client.defaultTags...),
+		MaxTags:         client.maxTags,
+		RefreshInterval: client.refreshInterval,
+		CloseTimeout:    client.closeTimeout,
+	}
+	switch {
+	case client.writer != nil:
+		config.Transport = "writer"
+	case client.packetConn != nil:
+		config.Transport = "packetconn"
+	case client.socketPath != "":
+		config.Transport = "unix"
+	}
+	if client.typePrefixes != nil {
+		config.TypePrefixes = make(map[string]string, len(client.typePrefixes))
+		for metric, prefix := range client.typePrefixes {
+			config.TypePrefixes[metric] = prefix
+		}
+	}
+	if client.buffer != nil {
+		config.Buffered = true
+		config.FlushCount = client.buffer.policy.Count
+		config.FlushPolicy = client.buffer.policy
+	}
+	if client.async != nil {
+		config.BestEffortQueue = cap(client.async.queue)
+	}
+	return config
+}
@@ -0,0 +1,58 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	client := NewLazy("statsd.local", 8125,
+		WithPrefix("app"),
+		WithTypePrefix("c", "count"),
+		WithSeparator("_"),
+		WithTags("env:prod"),
+		WithMaxTags(4),
+		WithBuffering(50),
+		WithBestEffort(100),
+		WithRefreshInterval(time.Minute),
+		WithCloseTimeout(time.Second))
+	defer client.Close()
+	want := Config{
+		Host:            "statsd.local",
+		Port:            8125,
+		Transport:       "udp",
+		Prefix:          "app",
+		TypePrefixes:    map[string]string{"c": "count"},
+		Separator:       "_",
+		Tags:            []string{"env:prod"},
+		MaxTags:         4,
+		Buffered:        true,
+		FlushCount:      50,
+		FlushPolicy:     FlushPolicy{Count: 50},
+		BestEffortQueue: 100,
+		RefreshInterval: time.Minute,
+		CloseTimeout:    time.Second,
+	}
+	config := client.Config()
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("Config() = %+v, want %+v", config, want)
+	}
+	config.Tags[0] = "changed"
+	config.TypePrefixes["c"] = "changed"
+	if again := client.Config(); !reflect.DeepEqual(again, want) {
+		t.Fatalf("Config() returned shared state: %+v", again)
+	}
+}
+
+func TestConfigTransport(t *testing.T) {
+	for want, client := range map[string]*StatsdClient{
+		"writer":     NewWithWriter(&MemorySink{}),
+		"packetconn": NewWithPacketConn(listen(t), nil),
+		"unix":       {socketPath: "/var/run/statsd.sock"},
+	} {
+		if got := client.Config().Transport; got != want {
+			t.Errorf("Transport = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+/**
+ * Tags updates sent with the context-aware methods (IncrementCtx,
+ * GaugeCtx, ...) with "<tag>:<value>", value being ctx.Value(key), to carry
+ * a correlation or request ID without passing it explicitly. Contexts
+ * without the key add no tag; values are cleaned like WithTags tags
+ * Usage:
+ *
+ * import "statsd"
+ * type ctxKey struct{}
+ * client := statsd.New('localhost', 8125, statsd.WithContextTag(ctxKey{}, "correlation_id"))
+ * ctx := context.WithValue(context.Background(), ctxKey{}, "abc123")
+ * client.IncrementCtx(ctx, "foo") // foo:1|c|#correlation_id:abc123
+ **/
+func WithContextTag(key interface{}, tag string) Option {
+	return func(client *StatsdClient) {
+		client.contextTags = append(client.contextTags, contextTag{key: key, tag: tag})
+	}
+}
+
+type contextTag struct {
+	key interface{}
+	tag string
+}
+
+/**
+ * Increments one stat counter without sampling, tagged from ctx (see
+ * WithContextTag). Like all context-aware methods, the write of an
+ * unbuffered, synchronous client must complete by the ctx deadline, if it
+ * has one, which overrides WithWriteTimeout for the call
+ **/
+func (client *StatsdClient) IncrementCtx(ctx context.Context, stat string) {
+	client.IncrementByValueCtx(ctx, stat, 1)
+}
+
+/**
+ * Increments one stat counter by value provided without sampling, tagged
+ * from ctx (see WithContextTag)
+ **/
+func (client *StatsdClient) IncrementByValueCtx(ctx context.Context, stat string, val int) {
+	client.sendCtx(ctx, map[string]string{stat: strconv.Itoa(val) + "|c"})
+}
+
+/**
+ * Gauge without sampling, tagged from ctx (see WithContextTag)
+ **/
+func (client *StatsdClient) GaugeCtx(ctx context.Context, stat string, value int) {
+	client.sendCtx(ctx, map[string]string{stat: strconv.Itoa(value) + "|g"})
+}
+
+/**
+ * Log timing information (in milliseconds) without sampling, tagged from
+ * ctx (see WithContextTag)
+ **/
+func (client *StatsdClient) TimingCtx(ctx context.Context, stat string, time int64) {
+	client.sendCtx(ctx, map[string]string{stat: strconv.FormatInt(time, 10) + "|ms"})
+}
+
+// sendCtx sends data tagged from ctx. Unbuffered synchronous writes use the
+// ctx deadline, if any, instead of the WithWriteTimeout one.
+func (client *StatsdClient) sendCtx(ctx context.Context, data map[string]string) {
+	lines := client.formatTagged(data, 1, client.ctxTags(ctx))
+	deadline, ok := ctx.Deadline()
+	if !ok || client.buffer != nil || client.async != nil {
+		client.dispatch(lines)
+		return
+	}
+	for _, line := range lines {
+		client.writeBy(line, deadline)
+	}
+}
+
+// ctxTags returns the tags WithContextTag extracts from ctx.
+func (client *StatsdClient) ctxTags(ctx context.Context) []string {
+	var tags []string
+	for _, t := range client.contextTags {
+		if value := ctx.Value(t.key); value != nil {
+			tags = append(tags, t.tag+":"+fmt.Sprint(value))
+		}
+	}
+	return tags
+}
@@ -0,0 +1,107 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type correlationKey struct{}
+
+func TestWithContextTag(t *testing.T) {
+	client, sink := newTestClient(WithTags("env:prod"), WithContextTag(correlationKey{}, "correlation_id"))
+	ctx := context.WithValue(context.Background(), correlationKey{}, "abc123")
+	client.IncrementCtx(ctx, "foo")
+	client.GaugeCtx(ctx, "bar", 2)
+	client.TimingCtx(context.Background(), "baz", 5)
+	expectLines(t, sink, "foo:1|c|#env:prod,correlation_id:abc123",
+		"bar:2|g|#env:prod,correlation_id:abc123", "baz:5|ms|#env:prod")
+}
+
+func TestContextTagIsCleaned(t *testing.T) {
+	client, sink := newTestClient(WithContextTag(correlationKey{}, "correlation_id"))
+	ctx := context.WithValue(context.Background(), correlationKey{}, "a,b|c#d\ne f")
+	client.IncrementCtx(ctx, "foo")
+	expectLines(t, sink, "foo:1|c|#correlation_id:a_b_c_d_e_f")
+}
+
+// deadlineWriter records the write deadline each datagram was written with.
+type deadlineWriter struct {
+	mu       sync.Mutex
+	deadline time.Time
+	written  map[string]time.Time
+}
+
+func (w *deadlineWriter) SetWriteDeadline(deadline time.Time) error {
+	w.mu.Lock()
+	w.deadline = deadline
+	w.mu.Unlock()
+	runtime.Gosched()
+	return nil
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.written == nil {
+		w.written = make(map[string]time.Time)
+	}
+	w.written[string(p)] = w.deadline
+	return len(p), nil
+}
+
+func TestContextDeadlineOverridesWriteTimeout(t *testing.T) {
+	w := &deadlineWriter{}
+	client := NewWithWriter(w, WithWriteTimeout(time.Hour))
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	client.IncrementCtx(ctx, "critical")
+	before := time.Now()
+	client.Increment("default")
+	if got := w.written["critical:1|c"]; !got.Equal(deadline) {
+		t.Errorf("IncrementCtx wrote with deadline %v, want the ctx one %v", got, deadline)
+	}
+	if got := w.written["default:1|c"]; got.Before(before.Add(time.Hour)) || got.After(time.Now().Add(time.Hour)) {
+		t.Errorf("Increment wrote with deadline %v, want an hour from now", got)
+	}
+}
+
+func TestConcurrentDeadlinesDoNotMix(t *testing.T) {
+	w := &deadlineWriter{}
+	client := NewWithWriter(w)
+	base := time.Now().Add(time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				stat := fmt.Sprintf("s%d.%d", i, j)
+				if i%2 == 0 {
+					client.Increment(stat)
+					continue
+				}
+				ctx, cancel := context.WithDeadline(context.Background(), base.Add(time.Duration(i*100+j)))
+				client.IncrementCtx(ctx, stat)
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for packet, got := range w.written {
+		var i, j int
+		fmt.Sscanf(strings.TrimSuffix(packet, ":1|c"), "s%d.%d", &i, &j)
+		want := time.Time{}
+		if i%2 == 1 {
+			want = base.Add(time.Duration(i*100 + j))
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q written with deadline %v, want %v", packet, got, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+)
+
+/**
+ * Like SendWait, but also returns the exact bytes written: the datagrams,
+ * with header and delimiters, one per update and newline separated. An
+ * update sampled out or filtered writes, and returns, nothing
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * wire, err := client.Emit(map[string]string{"deploys": "1|c"}, 1) // "deploys:1|c"
+ **/
+func (client *StatsdClient) Emit(data map[string]string, sampleRate float32) ([]byte, error) {
+	var written []string
+	var firstErr error
+	for _, line := range client.format(data, sampleRate) {
+		if err := client.writeNow(line); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		written = append(written, client.datagram(line))
+	}
+	if written == nil {
+		return nil, firstErr
+	}
+	return []byte(strings.Join(written, "\n")), firstErr
+}
+
+/**
+ * Increments one stat counter without sampling, writing immediately and
+ * returning the bytes written (see Emit)
+ **/
+func (client *StatsdClient) EmitIncrement(stat string) ([]byte, error) {
+	return client.Emit(map[string]string{stat: "1|c"}, 1)
+}
+
+/**
+ * Gauge without sampling, writing immediately and returning the bytes
+ * written (see Emit)
+ **/
+func (client *StatsdClient) EmitGauge(stat string, value int) ([]byte, error) {
+	return client.Emit(map[string]string{stat: strconv.Itoa(value) + "|g"}, 1)
+}
+
+/**
+ * Log timing information (in milliseconds) without sampling, writing
+ * immediately and returning the bytes written (see Emit)
+ **/
+func (client *StatsdClient) EmitTiming(stat string, time int64) ([]byte, error) {
+	return client.Emit(map[string]string{stat: strconv.FormatInt(time, 10) + "|ms"}, 1)
+}
@@ -0,0 +1,32 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEmitReturnsWireBytes(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithPacketHeader([]byte("h:")), WithTags("env:prod"), WithBuffering(10))
+	wire, err := client.EmitIncrement("foo")
+	if err != nil || string(wire) != "h:foo:1|c|#env:prod" {
+		t.Fatalf("EmitIncrement = %q, %v", wire, err)
+	}
+	client.EmitGauge("bar", 3)
+	client.EmitTiming("baz", 7)
+	want := []string{"h:foo:1|c|#env:prod", "h:bar:3|g|#env:prod", "h:baz:7|ms|#env:prod"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+	wire, _ = client.Emit(map[string]string{"a": "1|c", "b": "2|c"}, 1)
+	if string(wire) != "h:a:1|c|#env:prod\nh:b:2|c|#env:prod" {
+		t.Errorf("Emit = %q", wire)
+	}
+}
+
+func TestEmitReturnsWriteErrors(t *testing.T) {
+	client := NewWithWriter(failingWriter{})
+	if wire, err := client.EmitIncrement("foo"); err == nil || wire != nil {
+		t.Fatalf("EmitIncrement = %q, %v, want no bytes and the error", wire, err)
+	}
+}
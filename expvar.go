@@ -0,0 +1,60 @@
+package statsd
+
+import (
+	"expvar"
+	"strconv"
+	"time"
+)
+
+/**
+ * Starts a goroutine publishing expvar variables as gauges every interval,
+ * each under its key in vars. Numeric variables (expvar.Int, expvar.Float,
+ * expvar.Func returning a number, ...) are gauged as is; an expvar.Map is
+ * published entry by entry as "<key>.<entry>"; anything else is skipped.
+ * The goroutine stops on Close; none is started for a non-positive
+ * interval
+ * Usage:
+ *
+ * import "expvar"
+ * import "statsd"
+ * import "time"
+ * requests := expvar.NewInt("requests")
+ * client := statsd.New('localhost', 8125)
+ * client.PublishExpvars(map[string]expvar.Var{"app.requests": requests}, 10*time.Second)
+ **/
+func (client *StatsdClient) PublishExpvars(vars map[string]expvar.Var, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	client.background(func(quit <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				client.publishExpvars(vars)
+			case <-quit:
+				return
+			}
+		}
+	})
+}
+
+// publishExpvars runs one publish cycle.
+func (client *StatsdClient) publishExpvars(vars map[string]expvar.Var) {
+	for _, stat := range sortedKeys(vars) {
+		client.publishExpvar(stat, vars[stat])
+	}
+}
+
+func (client *StatsdClient) publishExpvar(stat string, v expvar.Var) {
+	if m, ok := v.(*expvar.Map); ok {
+		m.Do(func(kv expvar.KeyValue) {
+			client.publishExpvar(client.join(stat, kv.Key), kv.Value)
+		})
+		return
+	}
+	if value, err := strconv.ParseFloat(v.String(), 64); err == nil {
+		client.GaugeFloat(stat, value)
+	}
+}
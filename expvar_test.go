@@ -0,0 +1,40 @@
+package statsd
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestPublishExpvars(t *testing.T) {
+	requests, load, hits := new(expvar.Int), new(expvar.Float), new(expvar.Map)
+	requests.Set(42)
+	load.Set(-0.5)
+	hits.Add("a", 3)
+	vars := map[string]expvar.Var{
+		"app.requests": requests,
+		"app.load":     load,
+		"app.hits":     hits,
+		"app.name":     new(expvar.String),
+	}
+	client, sink := newTestClient()
+	client.publishExpvars(vars)
+	expectLines(t, sink, "app.hits.a:3|g", "app.load:0|g", "app.load:-0.5|g", "app.requests:42|g")
+
+	sink.Reset()
+	client.PublishExpvars(map[string]expvar.Var{"app.requests": requests}, time.Millisecond)
+	eventually(t, func() bool { return len(sink.Lines()) > 0 })
+	client.Close()
+	if lines := sink.Lines(); lines[0] != "app.requests:42|g" {
+		t.Fatalf("published %q, want app.requests:42|g", lines[0])
+	}
+}
+
+func TestPublishExpvarsZeroInterval(t *testing.T) {
+	requests := new(expvar.Int)
+	client, sink := newTestClient()
+	client.PublishExpvars(map[string]expvar.Var{"app.requests": requests}, 0)
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	expectLines(t, sink)
+}
@@ -0,0 +1,46 @@
+package statsd
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/**
+ * Wraps an http.RoundTripper (http.DefaultTransport when next is nil) so
+ * every outbound request is timed as "http.client.<host>", tagged with the
+ * request method and the response status ("status:error" when the round
+ * trip failed)
+ * Usage:
+ *
+ * import "net/http"
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * httpClient := &http.Client{Transport: client.RoundTripper(nil)}
+ **/
+func (client *StatsdClient) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{client: client, next: next}
+}
+
+type roundTripper struct {
+	client *StatsdClient
+	next   http.RoundTripper
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	stat := rt.client.Name("http", "client", req.URL.Hostname())
+	tags := []string{"method:" + req.Method, "status:" + status}
+	rt.client.dispatch(rt.client.formatTagged(map[string]string{stat: millis(elapsed) + "|ms"}, 1, tags))
+	return resp, err
+}
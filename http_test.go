@@ -0,0 +1,27 @@
+package statsd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	client, sink := newTestClient()
+	httpClient := &http.Client{Transport: client.RoundTripper(nil)}
+	resp, err := httpClient.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp.Body.Close()
+	lines := sink.Lines()
+	want := regexp.MustCompile(`^http\.client\.127_0_0_1:[0-9.]+\|ms\|#method:POST,status:418$`)
+	if len(lines) != 1 || !want.MatchString(lines[0]) {
+		t.Fatalf("lines = %q, want one matching %s", lines, want)
+	}
+}
@@ -0,0 +1,64 @@
+package statsd
+
+import (
+	"log"
+	"os"
+)
+
+/**
+ * Emits a "lifecycle.start" counter when the client is constructed and a
+ * "lifecycle.stop" counter on Close, both tagged with the given version and
+ * the host name, to mark deployments on dashboards
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithLifecycle("1.4.2"))
+ * defer client.Close()
+ **/
+func WithLifecycle(version string) Option {
+	return func(client *StatsdClient) {
+		client.lifecycleTags = []string{"version:" + version, "host:" + client.host()}
+	}
+}
+
+/**
+ * Attaches the host name, resolved once when the client is constructed,
+ * as a "<key>:<host>" tag to every update. When the host name cannot be
+ * resolved the failure is logged and "unknown" is used
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithHostnameTag("host"))
+ * client.Increment("foo") // foo:1|c|#host:web-1
+ **/
+func WithHostnameTag(key string) Option {
+	return func(client *StatsdClient) {
+		client.defaultTags = append(client.defaultTags, key+":"+client.host())
+	}
+}
+
+// host returns the host name, or "unknown" if it cannot be resolved. The
+// name is resolved, and a failure logged, only on the first call.
+func (client *StatsdClient) host() string {
+	client.hostOnce.Do(func() {
+		hostname := client.hostname
+		if hostname == nil {
+			hostname = os.Hostname
+		}
+		host, err := hostname()
+		if err != nil {
+			log.Println(err)
+			host = "unknown"
+		}
+		client.hostName = host
+	})
+	return client.hostName
+}
+
+// lifecycle emits a lifecycle event when WithLifecycle is enabled.
+func (client *StatsdClient) lifecycle(stat string) {
+	if client.lifecycleTags == nil {
+		return
+	}
+	client.dispatch(client.formatTagged(map[string]string{stat: "1|c"}, 1, client.lifecycleTags))
+}
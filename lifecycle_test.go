@@ -0,0 +1,55 @@
+package statsd
+
+import (
+	"errors"
+	"testing"
+)
+
+// withHostname makes the client resolve its host name with fn.
+func withHostname(fn func() (string, error)) Option {
+	return func(client *StatsdClient) {
+		client.hostname = fn
+	}
+}
+
+func TestWithLifecycle(t *testing.T) {
+	client, sink := newTestClient(withHostname(func() (string, error) { return "web-1", nil }),
+		WithLifecycle("1.4.2"))
+	expectLines(t, sink, "lifecycle.start:1|c|#version:1.4.2,host:web-1")
+	client.Increment("foo")
+	client.Close()
+	client.Close()
+	expectLines(t, sink, "lifecycle.start:1|c|#version:1.4.2,host:web-1", "foo:1|c",
+		"lifecycle.stop:1|c|#version:1.4.2,host:web-1")
+}
+
+func TestWithHostnameTag(t *testing.T) {
+	client, sink := newTestClient(withHostname(func() (string, error) { return "web-1", nil }),
+		WithHostnameTag("host"))
+	client.Increment("foo")
+	expectLines(t, sink, "foo:1|c|#host:web-1")
+
+	logged := captureLog(t)
+	client, sink = newTestClient(withHostname(func() (string, error) { return "", errors.New("no hostname") }),
+		WithHostnameTag("host"))
+	client.Increment("foo")
+	expectLines(t, sink, "foo:1|c|#host:unknown")
+	if logged.String() != "no hostname\n" {
+		t.Errorf("logged %q, want the resolution failure", logged)
+	}
+}
+
+func TestHostResolvedOnce(t *testing.T) {
+	logged := captureLog(t)
+	calls := 0
+	client, sink := newTestClient(withHostname(func() (string, error) {
+		calls++
+		return "", errors.New("no hostname")
+	}), WithLifecycle("1.4.2"), WithHostnameTag("host"))
+	client.Increment("foo")
+	expectLines(t, sink, "lifecycle.start:1|c|#host:unknown,version:1.4.2,host:unknown",
+		"foo:1|c|#host:unknown")
+	if calls != 1 || logged.String() != "no hostname\n" {
+		t.Errorf("resolved %d times and logged %q, want once", calls, logged)
+	}
+}
@@ -0,0 +1,160 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nameLimiter allows at most perSecond updates per stat name in each one
+// second window, tracking at most maxNames names.
+type nameLimiter struct {
+	mu        sync.Mutex
+	perSecond int
+	maxNames  int
+	windows   map[string]*nameWindow
+	throttled map[string]bool // names counted as "throttled.<name>"
+}
+
+type nameWindow struct {
+	start time.Time
+	count int
+}
+
+/**
+ * Limits every stat name to perSecond updates per second, so one runaway
+ * name cannot flood the pipeline. Excess updates are dropped and counted in
+ * Snapshot as "throttled.<name>". At most maxNames names are tracked; when
+ * the table is full and no window has expired, new names are not limited.
+ * Likewise at most maxNames names get a "throttled.<name>" count; the
+ * updates of further throttled names are counted as "throttled.other"
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithNameRateLimit(100, 10000))
+ **/
+func WithNameRateLimit(perSecond int, maxNames int) Option {
+	return func(client *StatsdClient) {
+		client.nameLimiter = &nameLimiter{
+			perSecond: perSecond,
+			maxNames:  maxNames,
+			windows:   make(map[string]*nameWindow),
+			throttled: make(map[string]bool),
+		}
+	}
+}
+
+func (l *nameLimiter) allow(stat string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	window, ok := l.windows[stat]
+	if !ok {
+		if len(l.windows) >= l.maxNames {
+			l.prune(now)
+			if len(l.windows) >= l.maxNames {
+				return true
+			}
+		}
+		window = &nameWindow{start: now}
+		l.windows[stat] = window
+	}
+	if now.Sub(window.start) >= time.Second {
+		window.start, window.count = now, 0
+	}
+	window.count++
+	return window.count <= l.perSecond
+}
+
+// throttledKey returns the Snapshot key counting the throttled updates of
+// stat.
+func (l *nameLimiter) throttledKey(stat string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.throttled[stat] {
+		if len(l.throttled) >= l.maxNames {
+			return "throttled.other"
+		}
+		l.throttled[stat] = true
+	}
+	return "throttled." + stat
+}
+
+// prune forgets the names whose window has expired.
+func (l *nameLimiter) prune(now time.Time) {
+	for stat, window := range l.windows {
+		if now.Sub(window.start) >= time.Second {
+			delete(l.windows, stat)
+		}
+	}
+}
+
+// maxSampledNames bounds the number of stats WithCountingSampler counts
+// separately.
+const maxSampledNames = 10000
+
+// countingSampler keeps every nth update of each stat name.
+type countingSampler struct {
+	mu      sync.Mutex
+	n       int
+	upscale bool
+	calls   map[string]int
+	other   int // calls of the stats beyond maxSampledNames
+}
+
+/**
+ * Deterministic sampling: of the updates of each stat name only every nth
+ * one is sent (the nth, 2nth, ...). With upscale, counter values are
+ * multiplied by n so totals stay right. Once maxSampledNames stats are
+ * counted, the updates of new stats share one count
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithCountingSampler(5, true))
+ * for i := 0; i < 10; i++ {
+ *     client.Increment("foo") // foo:5|c on the 5th and 10th call
+ * }
+ **/
+func WithCountingSampler(n int, upscale bool) Option {
+	if n < 1 {
+		n = 1
+	}
+	return func(client *StatsdClient) {
+		client.counting = &countingSampler{
+			n:       n,
+			upscale: upscale,
+			calls:   make(map[string]int),
+		}
+	}
+}
+
+// sample counts a call for stat and reports whether the update should be
+// sent, along with the (possibly upscaled) update string.
+func (c *countingSampler) sample(stat string, value string) (string, bool) {
+	c.mu.Lock()
+	_, tracked := c.calls[stat]
+	tracked = tracked || len(c.calls) < maxSampledNames
+	calls := c.other
+	if tracked {
+		calls = c.calls[stat]
+	}
+	calls++
+	keep := calls%c.n == 0
+	if keep {
+		calls = 0
+	}
+	if tracked {
+		c.calls[stat] = calls
+	} else {
+		c.other = calls
+	}
+	c.mu.Unlock()
+	if !keep || !c.upscale || metricType(value) != "c" {
+		return value, keep
+	}
+	number, rest, _ := strings.Cut(value, "|")
+	if delta, err := strconv.Atoi(number); err == nil {
+		value = strconv.Itoa(delta*c.n) + "|" + rest
+	}
+	return value, true
+}
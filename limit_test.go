@@ -0,0 +1,67 @@
+package statsd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithNameRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	client, sink := newTestClient(withClock(clock), WithNameRateLimit(3, 100))
+	for i := 0; i < 10; i++ {
+		client.Increment("hot")
+	}
+	client.Increment("cold")
+	client.Increment("cold")
+	if n := len(sink.Lines()); n != 5 {
+		t.Fatalf("sent %d updates, want 3 hot and 2 cold", n)
+	}
+	if throttled := client.Snapshot()["throttled.hot"]; throttled != 7 {
+		t.Errorf("throttled.hot = %d, want 7", throttled)
+	}
+	clock.advance(time.Second)
+	client.Increment("hot")
+	if n := len(sink.Lines()); n != 6 {
+		t.Errorf("hot name still throttled in the next second")
+	}
+}
+
+func TestThrottledKeysAreBounded(t *testing.T) {
+	clock := newFakeClock()
+	client, _ := newTestClient(withClock(clock), WithNameRateLimit(1, 3))
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 2; j++ {
+			client.Increment(fmt.Sprintf("name.%d", i))
+		}
+		clock.advance(time.Second)
+	}
+	snapshot := client.Snapshot()
+	keys := 0
+	for key := range snapshot {
+		if strings.HasPrefix(key, "throttled.") {
+			keys++
+		}
+	}
+	if keys > 4 {
+		t.Errorf("%d throttled keys in Snapshot, want at most 3 names and other: %v", keys, snapshot)
+	}
+	if snapshot["throttled.name.0"] != 1 || snapshot["throttled.other"] != 2 {
+		t.Errorf("Snapshot() = %v", snapshot)
+	}
+}
+
+func TestCountingSamplerIsBounded(t *testing.T) {
+	client, sink := newTestClient(WithCountingSampler(2, false))
+	for i := 0; i < maxSampledNames; i++ {
+		client.Increment(fmt.Sprintf("foo.%d", i))
+	}
+	client.Increment("new.a")
+	client.Increment("new.b")
+	client.Increment("foo.0")
+	if n := len(client.counting.calls); n != maxSampledNames {
+		t.Errorf("%d stats counted, want %d", n, maxSampledNames)
+	}
+	expectLines(t, sink, "new.b:1|c", "foo.0:1|c")
+}
@@ -0,0 +1,57 @@
+package statsd
+
+import (
+	"log"
+	"sync"
+)
+
+// maxLintNames bounds the number of stats WithTypeLint remembers.
+const maxLintNames = 10000
+
+// typeLint remembers the first type each stat was sent with.
+type typeLint struct {
+	mu     sync.Mutex
+	types  map[string]string
+	warned map[string]bool
+}
+
+/**
+ * Logs a warning when a stat is sent with a type different from the one
+ * it was first sent with (say as a counter, then as a gauge), which
+ * usually means two call sites disagree on what the metric is. Each stat
+ * is warned about once; mismatches are counted as "lint.mismatch" in
+ * Snapshot. At most maxLintNames stats are tracked
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithTypeLint())
+ * client.Increment("foo")
+ * client.Gauge("foo", 1) // logs: statsd: foo sent as g, first sent as c
+ **/
+func WithTypeLint() Option {
+	return func(client *StatsdClient) {
+		client.lint = &typeLint{types: make(map[string]string), warned: make(map[string]bool)}
+	}
+}
+
+// lintType records the type of an update and warns if it changed.
+func (client *StatsdClient) lintType(stat string, metric string) {
+	l := client.lint
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	first, seen := l.types[stat]
+	if !seen {
+		if len(l.types) < maxLintNames {
+			l.types[stat] = metric
+		}
+		return
+	}
+	if first == metric {
+		return
+	}
+	client.stats.add("lint.mismatch", 1)
+	if !l.warned[stat] {
+		l.warned[stat] = true
+		log.Printf("statsd: %s sent as %s, first sent as %s", stat, metric, first)
+	}
+}
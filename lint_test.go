@@ -0,0 +1,34 @@
+package statsd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWithTypeLint(t *testing.T) {
+	logged := captureLog(t)
+	client, sink := newTestClient(WithTypeLint())
+	client.Increment("foo")
+	client.Increment("foo")
+	client.Gauge("foo", 1)
+	client.Timing("foo", 5)
+	client.Gauge("bar", 1)
+	expectLines(t, sink, "foo:1|c", "foo:1|c", "foo:1|g", "foo:5|ms", "bar:1|g")
+	if got := logged.String(); strings.Count(got, "statsd: foo sent as") != 1 ||
+		!strings.Contains(got, "statsd: foo sent as g, first sent as c") {
+		t.Errorf("logged %q, want one warning about foo", got)
+	}
+	if snapshot := client.Snapshot(); snapshot["lint.mismatch"] != 2 {
+		t.Errorf("Snapshot() = %v, want 2 mismatches", snapshot)
+	}
+}
+
+func TestWithTypeLintOff(t *testing.T) {
+	logged := captureLog(t)
+	client, _ := newTestClient()
+	client.Increment("foo")
+	client.Gauge("foo", 1)
+	if logged.Len() != 0 {
+		t.Errorf("logged %q without WithTypeLint", logged)
+	}
+}
@@ -0,0 +1,138 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/**
+ * A single metric update, as consumed by SendAll and passed through
+ * middleware (see Use)
+ **/
+type Metric struct {
+	Name       string
+	Value      string   // formatted value, e.g. "1" or "12.5"
+	Type       string   // statsd type, e.g. "c", "g", "ms"
+	SampleRate float32  // sample rate of the update, zero is treated as 1 by SendAll
+	Tags       []string // tags of this update, in addition to the client's
+
+	fields string // further wire fields, e.g. "@0.5" for CountWithRate
+	reset  bool   // a negative gauge, sent after a reset to 0 (see GaugeFloat)
+}
+
+/**
+ * A step wrapped around the client's emit core. It receives each update
+ * before sampling, prefixes and formatting are applied, and calls next to
+ * pass it on, possibly modified, or does not call it to drop the update
+ **/
+type Middleware func(m Metric, next func(Metric))
+
+/**
+ * Appends middleware to the client's chain; the first one added runs
+ * first. Call it before the client is used from several goroutines
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Use(func(m statsd.Metric, next func(statsd.Metric)) {
+ *     m.Tags = append(m.Tags, "team:search")
+ *     next(m)
+ * })
+ **/
+func (client *StatsdClient) Use(mw ...Middleware) {
+	client.middleware = append(client.middleware, mw...)
+}
+
+// chain runs m through the middleware from index i on, ending in core.
+func (client *StatsdClient) chain(i int, m Metric, core func(Metric)) {
+	if i == len(client.middleware) {
+		core(m)
+		return
+	}
+	client.middleware[i](m, func(m Metric) {
+		client.chain(i+1, m, core)
+	})
+}
+
+/**
+ * Reads metrics from ch until it is closed and emits them. Metrics that are
+ * ready together are packed into shared datagrams (or go through the buffer
+ * in buffered mode). Metrics without a name or type are skipped; the first
+ * such problem is returned once the channel is drained
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * ch := make(chan statsd.Metric)
+ * go produce(ch)
+ * err := client.SendAll(ch)
+ **/
+func (client *StatsdClient) SendAll(ch <-chan Metric) error {
+	var firstErr error
+	var pending []string
+	for m := range ch {
+		if m.Name == "" || m.Type == "" {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("statsd: incomplete metric %+v", m)
+			}
+			continue
+		}
+		rate := m.SampleRate
+		if rate == 0 {
+			rate = 1
+		}
+		pending = append(pending, client.formatTagged(map[string]string{m.Name: m.Value + "|" + m.Type}, rate, m.Tags)...)
+		if len(ch) == 0 {
+			client.sendBatch(pending)
+			pending = nil
+		}
+	}
+	client.sendBatch(pending)
+	return firstErr
+}
+
+// sendBatch emits lines that belong together, packing them into shared
+// datagrams unless buffered mode takes care of that.
+func (client *StatsdClient) sendBatch(lines []string) {
+	if client.buffer != nil {
+		client.dispatch(lines)
+		return
+	}
+	client.flushLines(lines)
+}
+
+/**
+ * Emits a batch of pre-aggregated metrics, each with the "|@rate" suffix
+ * of its own SampleRate (none for a rate of 0 or 1) but, like
+ * CountWithRate, without sampling on the client. The batch is packed into
+ * shared datagrams bounded by MaxPacketSize and WithMaxLinesPerPacket (or
+ * goes through the buffer in buffered mode), whatever the mix of rates.
+ * Metrics without a name or type are skipped and reported as for SendAll
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.SendSampled([]statsd.Metric{
+ *     {Name: "hits", Value: "40", Type: "c", SampleRate: 0.1},
+ *     {Name: "misses", Value: "3", Type: "c", SampleRate: 0.5},
+ * }) // hits:40|c|@0.1\nmisses:3|c|@0.5
+ **/
+func (client *StatsdClient) SendSampled(metrics []Metric) error {
+	var firstErr error
+	var lines []string
+	for _, m := range metrics {
+		if m.Name == "" || m.Type == "" {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("statsd: incomplete metric %+v", m)
+			}
+			continue
+		}
+		value := m.Value + "|" + m.Type
+		if m.SampleRate > 0 && m.SampleRate < 1 {
+			value += "|@" + strconv.FormatFloat(float64(m.SampleRate), 'f', -1, 32)
+		}
+		lines = append(lines, client.formatTagged(map[string]string{m.Name: value}, 1, m.Tags)...)
+	}
+	client.sendBatch(lines)
+	return firstErr
+}
@@ -0,0 +1,64 @@
+package statsd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSendAll(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink)
+	ch := make(chan Metric, 4)
+	ch <- Metric{Name: "foo", Value: "1", Type: "c"}
+	ch <- Metric{Name: "bar", Value: "2", Type: "g"}
+	ch <- Metric{Name: "incomplete", Value: "3"}
+	ch <- Metric{Name: "baz", Value: "5", Type: "ms", Tags: []string{"a:b"}}
+	close(ch)
+	if err := client.SendAll(ch); err == nil {
+		t.Error("SendAll did not report the incomplete metric")
+	}
+	want := "foo:1|c\nbar:2|g\nbaz:5|ms|#a:b"
+	if got := sink.written(); len(got) != 1 || got[0] != want {
+		t.Fatalf("datagrams = %q, want one datagram %q", got, want)
+	}
+}
+
+func TestUseComposesMiddleware(t *testing.T) {
+	client, sink := newTestClient(WithPrefix("app"))
+	client.Use(func(m Metric, next func(Metric)) {
+		if m.Name != "debug" {
+			next(m)
+		}
+	}, func(m Metric, next func(Metric)) {
+		m.Tags = append(m.Tags, "team:search")
+		next(m)
+	})
+	client.Increment("foo")
+	client.Increment("debug")
+	client.GaugeWithUnit("bar", 1, "bytes")
+	expectLines(t, sink, "app.foo:1|c|#team:search", "app.bar:1|g|#unit:bytes,team:search")
+}
+
+func TestSendSampled(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithMaxLinesPerPacket(2))
+	err := client.SendSampled([]Metric{
+		{Name: "hits", Value: "40", Type: "c", SampleRate: 0.1},
+		{Name: "misses", Value: "3", Type: "c", SampleRate: 0.5},
+		{Name: "latency", Value: "12", Type: "ms", SampleRate: 0.25, Tags: []string{"a:b"}},
+		{Name: "incomplete", Value: "1"},
+		{Name: "total", Value: "43", Type: "c", SampleRate: 1},
+		{Name: "queue", Value: "7", Type: "g"},
+	})
+	if err == nil {
+		t.Error("SendSampled did not report the incomplete metric")
+	}
+	want := []string{
+		"hits:40|c|@0.1\nmisses:3|c|@0.5",
+		"latency:12|ms|@0.25|#a:b\ntotal:43|c",
+		"queue:7|g",
+	}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Errorf("datagrams = %q, want %q", got, want)
+	}
+}
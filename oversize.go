@@ -0,0 +1,87 @@
+package statsd
+
+import "strings"
+
+/**
+ * What to do with an update whose line alone, with its prefixes, tags,
+ * sample rate and the packet header, exceeds MaxPacketSize (or the
+ * WithMaxLineLength limit) and would not fit in a single datagram. No
+ * policy ever cuts a name or value: an update is sent whole, without some
+ * of its tags, or not at all
+ **/
+type OversizePolicy int
+
+const (
+	OversizeSend     OversizePolicy = iota // send it anyway, in its own datagram (default)
+	OversizeDrop                           // drop the update
+	OversizeTrimTags                       // drop tags, last first, until it fits; drop the update if it never does
+)
+
+/**
+ * Sets the handling of oversized updates. Updates dropped or trimmed are
+ * counted as "oversize" in Snapshot, dropped ones also as "dropped"
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithOversizePolicy(statsd.OversizeTrimTags))
+ **/
+func WithOversizePolicy(policy OversizePolicy) Option {
+	return func(client *StatsdClient) {
+		client.oversize = policy
+	}
+}
+
+/**
+ * Lowers the size above which the oversize policy applies from
+ * MaxPacketSize to n bytes per update, packet header included, to guard
+ * against pathological combinations of long prefixes, values and tags
+ * well before they reach the MTU
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithOversizePolicy(statsd.OversizeDrop),
+ *     statsd.WithMaxLineLength(512))
+ **/
+func WithMaxLineLength(n int) Option {
+	return func(client *StatsdClient) {
+		client.maxLine = n
+	}
+}
+
+// lineLimit is the size above which an update is oversized.
+func (client *StatsdClient) lineLimit() int {
+	if client.maxLine > 0 && client.maxLine < MaxPacketSize {
+		return client.maxLine
+	}
+	return MaxPacketSize
+}
+
+// fit applies the oversize policy to the line of an update, tags not
+// included, returning the line to send with its tags or false if it is
+// dropped.
+func (client *StatsdClient) fit(line string, tags []string) (string, bool) {
+	tagged := withTags(line, tags)
+	limit := client.lineLimit()
+	if client.oversize == OversizeSend || len(client.datagram(tagged)) <= limit {
+		return tagged, true
+	}
+	client.stats.add("oversize", 1)
+	if client.oversize == OversizeTrimTags {
+		for len(tags) > 0 {
+			tags = tags[:len(tags)-1]
+			if tagged = withTags(line, tags); len(client.datagram(tagged)) <= limit {
+				return tagged, true
+			}
+		}
+	}
+	client.stats.add("dropped", 1)
+	return "", false
+}
+
+func withTags(line string, tags []string) string {
+	if len(tags) == 0 {
+		return line
+	}
+	return line + "|#" + strings.Join(tags, ",")
+}
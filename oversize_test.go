@@ -0,0 +1,66 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOversizePolicy(t *testing.T) {
+	long := "k:" + strings.Repeat("v", 40)
+	tags := WithTags("env:prod", long)
+	for _, tt := range []struct {
+		name     string
+		policy   OversizePolicy
+		max      int
+		want     []string
+		oversize int64
+	}{
+		{"send", OversizeSend, 50, []string{"foo:1|c|@0.500000|#env:prod," + long}, 0},
+		{"drop", OversizeDrop, 50, nil, 1},
+		{"trim", OversizeTrimTags, 50, []string{"foo:1|c|@0.500000|#env:prod"}, 1},
+		{"trim all", OversizeTrimTags, 20, []string{"foo:1|c|@0.500000"}, 1},
+		{"never fits", OversizeTrimTags, 10, nil, 1},
+	} {
+		client, sink := newTestClient(keepAll(), tags, WithOversizePolicy(tt.policy), WithMaxLineLength(tt.max))
+		client.IncrementWithSampling("foo", 0.5)
+		if got := sink.Lines(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: lines = %q, want %q", tt.name, got, tt.want)
+		}
+		if oversize := client.Snapshot()["oversize"]; oversize != tt.oversize {
+			t.Errorf("%s: oversize = %d, want %d", tt.name, oversize, tt.oversize)
+		}
+	}
+}
+
+func TestOversizeNeverCutsValues(t *testing.T) {
+	prefix := strings.Repeat("p", MaxPacketSize/2)
+	var tags []string
+	for i := 0; i < 100; i++ {
+		tags = append(tags, fmt.Sprintf("tag%d:%s", i, strings.Repeat("v", 20)))
+	}
+	value := math.MaxInt64
+	line := prefix + ".foo:" + strconv.Itoa(value) + "|g"
+	kept := 0
+	for len(line+"|#"+strings.Join(tags[:kept+1], ",")) <= MaxPacketSize {
+		kept++
+	}
+	for _, tt := range []struct {
+		policy OversizePolicy
+		want   string
+	}{
+		{OversizeDrop, ""},
+		{OversizeTrimTags, line + "|#" + strings.Join(tags[:kept], ",")},
+	} {
+		client, sink := newTestClient(WithPrefix(prefix), WithTags(tags...), WithOversizePolicy(tt.policy))
+		client.Gauge("foo", value)
+		if got := strings.Join(sink.Lines(), "\n"); got != tt.want {
+			t.Errorf("policy %d: sent %d bytes %.60q..., want %d bytes", tt.policy, len(got), got, len(tt.want))
+		}
+		if oversize := client.Snapshot()["oversize"]; oversize != 1 {
+			t.Errorf("policy %d: oversize = %d, want 1", tt.policy, oversize)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package statsd
+
+import "path"
+
+/**
+ * A rule of WithTypeRemap: updates of type From ("c", "g", ...; empty for
+ * any type) to a stat whose name matches Pattern, a path.Match pattern
+ * such as "api.*.requests", are sent with type To instead
+ **/
+type TypeRule struct {
+	Pattern string
+	From    string
+	To      string
+}
+
+/**
+ * Re-types updates matching rules, the first matching rule winning, for
+ * moving metrics to a different type without changing call sites. Rules
+ * are matched against the stat name as passed by the caller, before
+ * prefixes are added
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithTypeRemap(
+ *     statsd.TypeRule{Pattern: "foo", From: "c", To: "g"}))
+ * client.Increment("foo") // foo:1|g
+ **/
+func WithTypeRemap(rules ...TypeRule) Option {
+	return func(client *StatsdClient) {
+		client.typeRules = append(client.typeRules, rules...)
+	}
+}
+
+// remapType returns the type an update of type metric to stat is sent as.
+func (client *StatsdClient) remapType(stat string, metric string) string {
+	for _, rule := range client.typeRules {
+		if rule.From != "" && rule.From != metric {
+			continue
+		}
+		if ok, _ := path.Match(rule.Pattern, stat); ok {
+			return rule.To
+		}
+	}
+	return metric
+}
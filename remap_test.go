@@ -0,0 +1,14 @@
+package statsd
+
+import "testing"
+
+func TestWithTypeRemap(t *testing.T) {
+	client, sink := newTestClient(WithPrefix("app"), WithTypeRemap(
+		TypeRule{Pattern: "foo", From: "c", To: "g"},
+		TypeRule{Pattern: "api.*.requests", To: "d"}))
+	client.Increment("foo")
+	client.Timing("foo", 5)
+	client.Timing("api.users.requests", 5)
+	client.Increment("bar")
+	expectLines(t, sink, "app.foo:1|g", "app.foo:5|ms", "app.api.users.requests:5|d", "app.bar:1|c")
+}
@@ -0,0 +1,89 @@
+package statsd
+
+import (
+	"strconv"
+	"sync"
+)
+
+// reservoirs keeps a uniform random sample of the timings of each stat
+// between flushes (Vitter's algorithm R).
+type reservoirs struct {
+	mu    sync.Mutex
+	size  int
+	stats map[string]*reservoir
+}
+
+type reservoir struct {
+	seen  int
+	lines []reservoirLine
+}
+
+// reservoirLine is a rendered timing, split around where the sample rate
+// goes.
+type reservoirLine struct {
+	update string
+	tags   string
+}
+
+/**
+ * Samples unsampled timings with a reservoir instead of sending each one:
+ * between flushes at most size timings per stat are kept, chosen uniformly
+ * at random so the distribution (and its percentiles) is preserved, and
+ * they are sent on Flush and Close with a "|@rate" suffix of kept/seen so
+ * the daemon still counts every timing
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithTimerReservoir(100))
+ * defer client.Close()
+ * for _, d := range durations {
+ *     client.Timing("foo.time", d)
+ * }
+ * client.Flush()
+ **/
+func WithTimerReservoir(size int) Option {
+	return func(client *StatsdClient) {
+		client.reservoirs = &reservoirs{size: size, stats: make(map[string]*reservoir)}
+	}
+}
+
+func (r *reservoirs) add(stat string, update string, tags string, random func() float32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res, ok := r.stats[stat]
+	if !ok {
+		res = &reservoir{}
+		r.stats[stat] = res
+	}
+	res.seen++
+	line := reservoirLine{update: update, tags: tags}
+	if len(res.lines) < r.size {
+		res.lines = append(res.lines, line)
+		return
+	}
+	if i := int(random() * float32(res.seen)); i < r.size {
+		res.lines[i] = line
+	}
+}
+
+// drain returns the kept timings as wire lines and starts a new window.
+func (r *reservoirs) drain() []string {
+	r.mu.Lock()
+	stats := r.stats
+	r.stats = make(map[string]*reservoir)
+	r.mu.Unlock()
+
+	var lines []string
+	for _, stat := range sortedKeys(stats) {
+		res := stats[stat]
+		var rate string
+		if res.seen > len(res.lines) {
+			// shortest form, as a fixed 6 digits would round small rates to 0
+			rate = "|@" + strconv.FormatFloat(float64(len(res.lines))/float64(res.seen), 'f', -1, 32)
+		}
+		for _, line := range res.lines {
+			lines = append(lines, line.update+rate+line.tags)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,83 @@
+package statsd
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// skewed returns n timings: mostly fast, with a slow tail.
+func skewed(n int) []float64 {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, n)
+	for i := range values {
+		if rng.Intn(10) == 0 {
+			values[i] = float64(1000 + rng.Intn(1000))
+		} else {
+			values[i] = float64(1 + rng.Intn(100))
+		}
+	}
+	return values
+}
+
+// ranks returns the fractions of sorted values below and at or below v.
+func ranks(sorted []float64, v float64) (float64, float64) {
+	n := float64(len(sorted))
+	below := sort.SearchFloat64s(sorted, v)
+	atOrBelow := sort.Search(len(sorted), func(i int) bool { return sorted[i] > v })
+	return float64(below) / n, float64(atOrBelow) / n
+}
+
+// withinRank reports whether v lies within tolerance of percentile p of
+// sorted, in rank.
+func withinRank(sorted []float64, v float64, p float64, tolerance float64) bool {
+	low, high := ranks(sorted, v)
+	return p/100 >= low-tolerance && p/100 <= high+tolerance
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	return sorted[int(p/100*float64(len(sorted)-1))]
+}
+
+func TestWithTimerReservoir(t *testing.T) {
+	input := skewed(10000)
+	client, sink := newTestClient(WithSeed(7), WithTimerReservoir(500))
+	for _, v := range input {
+		client.Timing("foo.time", int64(v))
+	}
+	expectLines(t, sink)
+	client.Flush()
+	lines := sink.Lines()
+	if len(lines) != 500 {
+		t.Fatalf("emitted %d timings, want the 500 kept", len(lines))
+	}
+	var kept []float64
+	for _, line := range lines {
+		value, ok := strings.CutPrefix(line, "foo.time:")
+		value, ok2 := strings.CutSuffix(value, "|ms|@0.05")
+		v, err := strconv.ParseFloat(value, 64)
+		if !ok || !ok2 || err != nil {
+			t.Fatalf("unexpected line %q", line)
+		}
+		kept = append(kept, v)
+	}
+	sort.Float64s(input)
+	sort.Float64s(kept)
+	for _, p := range []float64{50, 90, 95, 99} {
+		if v := percentile(kept, p); !withinRank(input, v, p, 0.05) {
+			t.Errorf("p%v of the reservoir is %v, of the input %v", p, v, percentile(input, p))
+		}
+	}
+}
+
+func TestReservoirRateBelowMicro(t *testing.T) {
+	r := &reservoirs{size: 1, stats: map[string]*reservoir{
+		"foo.time": {seen: 4000000, lines: []reservoirLine{{update: "foo.time:5|ms"}}},
+	}}
+	lines := r.drain()
+	if len(lines) != 1 || lines[0] != "foo.time:5|ms|@0.00000025" {
+		t.Fatalf("drained %q, want foo.time:5|ms|@0.00000025", lines)
+	}
+}
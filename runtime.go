@@ -0,0 +1,144 @@
+package statsd
+
+import (
+	"runtime"
+	"time"
+)
+
+/**
+ * Starts a goroutine that reads the runtime GC pause history every interval
+ * and emits each pause that happened since the previous read as a timing
+ * (in fractional milliseconds). The goroutine stops on Close; none is
+ * started for a non-positive interval
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125)
+ * client.StartGCPauseTiming("runtime.gc.pause", 10*time.Second)
+ **/
+func (client *StatsdClient) StartGCPauseTiming(stat string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	pauses := &gcPauses{read: client.readMemStats}
+	if pauses.read == nil {
+		pauses.read = runtime.ReadMemStats
+	}
+	pauses.next()
+
+	client.background(func(quit <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, ns := range pauses.next() {
+					client.TimingNanos(stat, int64(ns))
+				}
+			case <-quit:
+				return
+			}
+		}
+	})
+}
+
+/**
+ * Starts a goroutine gauging stat to 1 every interval, for liveness
+ * dashboards. The goroutine stops on Close; none is started for a
+ * non-positive interval
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125)
+ * client.StartHeartbeat("app.alive", 10*time.Second)
+ **/
+func (client *StatsdClient) StartHeartbeat(stat string, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	client.background(func(quit <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				client.Gauge(stat, 1)
+			case <-quit:
+				return
+			}
+		}
+	})
+}
+
+// startEpoch is the stat and start time gauged by WithStartEpoch.
+type startEpoch struct {
+	stat string
+	at   time.Time
+}
+
+/**
+ * Captures the time the client is constructed and gauges it, in Unix
+ * epoch seconds, as stat on every Flush (and Close) and, with a non-zero
+ * interval, every interval from a goroutine stopped by Close, so uptime
+ * dashboards can compute now - start on the server
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithStartEpoch("app.start", time.Minute))
+ **/
+func WithStartEpoch(stat string, interval time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.started = &startEpoch{stat: stat, at: client.clock()}
+		if interval <= 0 {
+			return
+		}
+		client.background(func(quit <-chan struct{}) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					client.gaugeStart()
+				case <-quit:
+					return
+				}
+			}
+		})
+	}
+}
+
+// gaugeStart gauges the start time captured by WithStartEpoch.
+func (client *StatsdClient) gaugeStart() {
+	client.Gauge(client.started.stat, int(client.started.at.Unix()))
+}
+
+// gcPauses tracks the position in the MemStats.PauseNs ring buffer so each
+// pause is reported exactly once.
+type gcPauses struct {
+	read   func(*runtime.MemStats)
+	stats  runtime.MemStats
+	lastGC uint32
+}
+
+// next returns the pauses recorded since the previous call, oldest first.
+func (p *gcPauses) next() []uint64 {
+	p.read(&p.stats)
+	count := p.stats.NumGC - p.lastGC
+	if count > uint32(len(p.stats.PauseNs)) {
+		count = uint32(len(p.stats.PauseNs))
+	}
+	pauses := make([]uint64, 0, count)
+	for gc := p.stats.NumGC - count; gc < p.stats.NumGC; gc++ {
+		pauses = append(pauses, p.stats.PauseNs[gc%uint32(len(p.stats.PauseNs))])
+	}
+	p.lastGC = p.stats.NumGC
+	return pauses
+}
@@ -0,0 +1,126 @@
+package statsd
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemStats serves MemStats set by the test.
+type fakeMemStats struct {
+	mu    sync.Mutex
+	stats runtime.MemStats
+}
+
+func (f *fakeMemStats) read(m *runtime.MemStats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*m = f.stats
+}
+
+// gc records a collection that paused for pause.
+func (f *fakeMemStats) gc(pause time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats.PauseNs[f.stats.NumGC%uint32(len(f.stats.PauseNs))] = uint64(pause)
+	f.stats.NumGC++
+}
+
+func TestStartGCPauseTiming(t *testing.T) {
+	mem := &fakeMemStats{}
+	mem.gc(time.Millisecond)
+	client, sink := newTestClient(func(client *StatsdClient) {
+		client.readMemStats = mem.read
+	})
+	defer client.Close()
+	client.StartGCPauseTiming("runtime.gc.pause", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if lines := sink.Lines(); len(lines) != 0 {
+		t.Fatalf("emitted pauses from before the start: %q", lines)
+	}
+	mem.gc(1500 * time.Microsecond)
+	mem.gc(3 * time.Millisecond)
+	eventually(t, func() bool { return len(sink.Lines()) == 2 })
+	time.Sleep(10 * time.Millisecond)
+	expectLines(t, sink, "runtime.gc.pause:1.5|ms", "runtime.gc.pause:3|ms")
+}
+
+func TestGCPausesWrapAround(t *testing.T) {
+	mem := &fakeMemStats{}
+	pauses := &gcPauses{read: mem.read}
+	for i := 0; i < 300; i++ {
+		mem.gc(time.Duration(i))
+	}
+	got := pauses.next()
+	if len(got) != 256 || got[0] != 44 || got[255] != 299 {
+		t.Fatalf("got %d pauses from %d to %d, want 256 from 44 to 299",
+			len(got), got[0], got[len(got)-1])
+	}
+	if got := pauses.next(); len(got) != 0 {
+		t.Fatalf("pauses reported twice: %v", got)
+	}
+}
+
+func TestStartHeartbeat(t *testing.T) {
+	client, sink := newTestClient()
+	client.StartHeartbeat("app.alive", time.Millisecond)
+	eventually(t, func() bool { return len(sink.Lines()) >= 2 })
+	client.Close()
+	after := len(sink.Lines())
+	time.Sleep(10 * time.Millisecond)
+	lines := sink.Lines()
+	if len(lines) != after {
+		t.Errorf("%d heartbeats after Close", len(lines)-after)
+	}
+	for _, line := range lines {
+		if line != "app.alive:1|g" {
+			t.Fatalf("heartbeat %q, want app.alive:1|g", line)
+		}
+	}
+}
+
+func TestWithStartEpoch(t *testing.T) {
+	clock := newFakeClock()
+	start := fmt.Sprintf("app.start:%d|g", clock.now().Unix())
+	client, sink := newTestClient(withClock(clock), WithStartEpoch("app.start", 0))
+	client.Flush()
+	clock.advance(time.Hour)
+	client.Flush()
+	client.Close()
+	expectLines(t, sink, start, start, start)
+}
+
+func TestWithStartEpochInterval(t *testing.T) {
+	client, sink := newTestClient(WithStartEpoch("app.start", time.Millisecond))
+	eventually(t, func() bool { return len(sink.Lines()) >= 2 })
+	client.Close()
+	start := sink.Lines()[0]
+	for _, line := range sink.Lines() {
+		if line != start {
+			t.Fatalf("gauged %q, then %q", start, line)
+		}
+	}
+}
+
+func TestStartHeartbeatZeroInterval(t *testing.T) {
+	client, sink := newTestClient()
+	client.StartHeartbeat("app.alive", 0)
+	client.StartHeartbeat("app.alive", -time.Second)
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	expectLines(t, sink)
+}
+
+func TestStartGCPauseTimingZeroInterval(t *testing.T) {
+	mem := &fakeMemStats{}
+	client, sink := newTestClient(func(client *StatsdClient) {
+		client.readMemStats = mem.read
+	})
+	client.StartGCPauseTiming("runtime.gc.pause", 0)
+	mem.gc(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	client.Close()
+	expectLines(t, sink)
+}
@@ -0,0 +1,20 @@
+package statsd
+
+import "testing"
+
+func TestSelfTest(t *testing.T) {
+	client, sink := newTestClient(WithPrefix("app"), WithTags("env:prod"), WithSequenceTag(),
+		WithNameRateLimit(1, 10))
+	result := client.SelfTest()
+	if result.Metrics != selfTestMetrics || result.Elapsed <= 0 || result.MetricsPerSecond <= 0 {
+		t.Errorf("SelfTest() = %+v, want positive throughput", result)
+	}
+	if result.AllocsPerMetric <= 0 || result.BytesPerMetric <= 0 {
+		t.Errorf("SelfTest() = %+v, want positive allocation figures", result)
+	}
+	if snapshot := client.Snapshot(); len(snapshot) != 0 {
+		t.Errorf("SelfTest changed Snapshot: %v", snapshot)
+	}
+	client.Increment("statsd.selftest")
+	expectLines(t, sink, "app.statsd.selftest:1|c|#env:prod,seq:1")
+}
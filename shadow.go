@@ -0,0 +1,62 @@
+package statsd
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// shadowQueueSize bounds the updates waiting for the shadow writer; once
+// full, further updates are dropped and counted as failures.
+const shadowQueueSize = 1024
+
+// shadow delivers updates to a secondary daemon without ever blocking or
+// failing the primary path.
+type shadow struct {
+	w        io.WriteCloser
+	queue    chan string
+	done     chan struct{}
+	failures int64
+}
+
+func newShadow(w io.WriteCloser) *shadow {
+	s := &shadow{
+		w:     w,
+		queue: make(chan string, shadowQueueSize),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *shadow) run() {
+	for {
+		select {
+		case update := <-s.queue:
+			if s.w == nil {
+				atomic.AddInt64(&s.failures, 1)
+				continue
+			}
+			if _, err := fmt.Fprint(s.w, update); err != nil {
+				atomic.AddInt64(&s.failures, 1)
+			}
+		case <-s.done:
+			if s.w != nil {
+				s.w.Close()
+			}
+			return
+		}
+	}
+}
+
+func (s *shadow) send(update string) {
+	select {
+	case s.queue <- update:
+	default:
+		atomic.AddInt64(&s.failures, 1)
+	}
+}
+
+func (s *shadow) close() {
+	close(s.done)
+}
@@ -0,0 +1,72 @@
+package statsd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/**
+ * A counter incremented lock-free from any number of goroutines and sent
+ * as a single counter update per flush, see NewSharedCounter
+ **/
+type SharedCounter struct {
+	client *StatsdClient
+	stat   string
+	value  int64
+}
+
+/**
+ * Creates a SharedCounter for stat. With a positive interval a goroutine
+ * flushes it every interval and once more on Close; otherwise call Flush
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125)
+ * hits := client.NewSharedCounter("cache.hits", time.Second)
+ * hits.Inc() // from any goroutine
+ **/
+func (client *StatsdClient) NewSharedCounter(stat string, interval time.Duration) *SharedCounter {
+	counter := &SharedCounter{client: client, stat: stat}
+	if interval > 0 {
+		client.background(func(quit <-chan struct{}) {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					counter.Flush()
+				case <-quit:
+					counter.Flush()
+					return
+				}
+			}
+		})
+	}
+	return counter
+}
+
+/**
+ * Adds delta to the counter
+ **/
+func (counter *SharedCounter) Add(delta int64) {
+	atomic.AddInt64(&counter.value, delta)
+}
+
+/**
+ * Adds one to the counter
+ **/
+func (counter *SharedCounter) Inc() {
+	atomic.AddInt64(&counter.value, 1)
+}
+
+/**
+ * Sends the total accumulated since the previous flush, resetting it
+ * atomically so no increment is lost or counted twice. Nothing is sent
+ * when the total is zero
+ **/
+func (counter *SharedCounter) Flush() {
+	if value := atomic.SwapInt64(&counter.value, 0); value != 0 {
+		counter.client.IncrementByValue(counter.stat, int(value))
+	}
+}
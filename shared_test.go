@@ -0,0 +1,52 @@
+package statsd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedCounter(t *testing.T) {
+	client, sink := newTestClient()
+	hits := client.NewSharedCounter("cache.hits", time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if j%2 == 0 {
+					hits.Inc()
+				} else {
+					hits.Add(2)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	client.Close()
+	total := 0
+	for _, line := range sink.Lines() {
+		value, ok := strings.CutPrefix(line, "cache.hits:")
+		n, err := strconv.Atoi(strings.TrimSuffix(value, "|c"))
+		if !ok || err != nil {
+			t.Fatalf("unexpected line %q", line)
+		}
+		total += n
+	}
+	if total != 16*1500 {
+		t.Fatalf("flushed %d in all, want %d", total, 16*1500)
+	}
+}
+
+func TestSharedCounterFlush(t *testing.T) {
+	client, sink := newTestClient()
+	hits := client.NewSharedCounter("cache.hits", 0)
+	hits.Flush()
+	hits.Add(3)
+	hits.Flush()
+	hits.Flush()
+	expectLines(t, sink, "cache.hits:3|c")
+}
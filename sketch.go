@@ -0,0 +1,142 @@
+package statsd
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// sketchAccuracy is the relative error of the percentiles reported by
+	// WithTimerPercentiles.
+	sketchAccuracy = 0.01
+	// sketchMaxBuckets bounds the memory of one sketch; past it the lowest
+	// buckets are merged, so only the smallest values lose accuracy.
+	sketchMaxBuckets = 2048
+)
+
+// digests keeps one sketch per timer stat between flushes.
+type digests struct {
+	mu          sync.Mutex
+	percentiles []float64
+	sketches    map[string]*sketch
+}
+
+/**
+ * Aggregates unsampled timings into a sketch per stat instead of sending
+ * each one, and on Flush and Close gauges the requested percentiles (given
+ * in percent) as "<stat>.p<percentile>", e.g. "foo.time.p99" or
+ * "foo.time.p99_9". Percentiles are accurate to within 1% of the true
+ * value and memory per stat is bounded, whatever the number of timings.
+ * The sketches are reset on every flush
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithTimerPercentiles(50, 90, 99))
+ * client.Timing("foo.time", 12)
+ * client.Flush() // foo.time.p50:12|g, foo.time.p90:12|g, foo.time.p99:12|g
+ **/
+func WithTimerPercentiles(percentiles ...float64) Option {
+	return func(client *StatsdClient) {
+		client.digests = &digests{
+			percentiles: percentiles,
+			sketches:    make(map[string]*sketch),
+		}
+	}
+}
+
+func (d *digests) add(stat string, value float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.sketches[stat]
+	if !ok {
+		s = newSketch()
+		d.sketches[stat] = s
+	}
+	s.add(value)
+}
+
+// flushDigests gauges the percentiles of every sketch and resets them.
+func (client *StatsdClient) flushDigests() {
+	d := client.digests
+	d.mu.Lock()
+	sketches := d.sketches
+	d.sketches = make(map[string]*sketch)
+	d.mu.Unlock()
+
+	for _, stat := range sortedKeys(sketches) {
+		for _, p := range d.percentiles {
+			label := "p" + strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+			client.GaugeFloat(client.join(stat, label), sketches[stat].quantile(p/100))
+		}
+	}
+}
+
+// sketch is a logarithmically bucketed histogram (as in DDSketch): every
+// bucket covers values within sketchAccuracy of its representative value.
+type sketch struct {
+	gamma   float64
+	buckets map[int]int
+	zeros   int // values <= 0
+	count   int
+}
+
+func newSketch() *sketch {
+	return &sketch{
+		gamma:   (1 + sketchAccuracy) / (1 - sketchAccuracy),
+		buckets: make(map[int]int),
+	}
+}
+
+func (s *sketch) add(value float64) {
+	s.count++
+	if value <= 0 {
+		s.zeros++
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(value)/math.Log(s.gamma)))]++
+	if len(s.buckets) > sketchMaxBuckets {
+		s.collapse()
+	}
+}
+
+// collapse merges the two lowest buckets.
+func (s *sketch) collapse() {
+	indexes := s.indexes()
+	s.buckets[indexes[1]] += s.buckets[indexes[0]]
+	delete(s.buckets, indexes[0])
+}
+
+func (s *sketch) indexes() []int {
+	indexes := make([]int, 0, len(s.buckets))
+	for index := range s.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// quantile returns the value at q (between 0 and 1).
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q * float64(s.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := s.zeros
+	if seen >= rank {
+		return 0
+	}
+	indexes := s.indexes()
+	for _, index := range indexes {
+		seen += s.buckets[index]
+		if seen >= rank {
+			return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+		}
+	}
+	return 2 * math.Pow(s.gamma, float64(indexes[len(indexes)-1])) / (s.gamma + 1)
+}
@@ -0,0 +1,49 @@
+package statsd
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWithTimerPercentiles(t *testing.T) {
+	client, sink := newTestClient(WithTimerPercentiles(50, 90, 99, 99.9))
+	for _, i := range rand.New(rand.NewSource(1)).Perm(10000) {
+		client.Timing("foo.time", int64(i+1))
+	}
+	expectLines(t, sink)
+	client.Flush()
+	want := map[string]float64{
+		"foo.time.p50": 5000, "foo.time.p90": 9000, "foo.time.p99": 9900, "foo.time.p99_9": 9990,
+	}
+	lines := sink.Lines()
+	if len(lines) != len(want) {
+		t.Fatalf("lines = %q, want one gauge per percentile", lines)
+	}
+	for _, line := range lines {
+		name, value, _ := strings.Cut(strings.TrimSuffix(line, "|g"), ":")
+		got, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.Abs(got-want[name]) > sketchAccuracy*want[name] {
+			t.Errorf("%s = %s, want %v within %v%%", name, value, want[name], sketchAccuracy*100)
+		}
+	}
+	sink.Reset()
+	client.Flush()
+	expectLines(t, sink)
+}
+
+func TestSketchBoundsMemory(t *testing.T) {
+	s, v := newSketch(), 1.0
+	for i := 0; i < sketchMaxBuckets+100; i++ {
+		s.add(v)
+		v *= 1.05
+	}
+	if len(s.buckets) > sketchMaxBuckets {
+		t.Fatalf("%d buckets, want at most %d", len(s.buckets), sketchMaxBuckets)
+	}
+	if top, max := s.quantile(1), v/1.05; math.Abs(top-max) > sketchAccuracy*max {
+		t.Errorf("max = %g after collapsing low buckets, want %g", top, max)
+	}
+}
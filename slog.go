@@ -0,0 +1,35 @@
+package statsd
+
+import (
+	"context"
+	"log/slog"
+)
+
+/**
+ * Mirrors every update the client sends to logger, as a debug record with
+ * the name, value, type and tags as attributes, to correlate logs and
+ * metrics. Updates are only logged when the logger has debug enabled, so
+ * leaving the option on costs one Enabled check per update otherwise
+ * Usage:
+ *
+ * import "log/slog"
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithSlog(slog.Default()))
+ **/
+func WithSlog(logger *slog.Logger) Option {
+	return func(client *StatsdClient) {
+		client.logger = logger
+	}
+}
+
+// mirror logs an update sent to the daemon when WithSlog is enabled.
+func (client *StatsdClient) mirror(name string, m Metric, tags []string) {
+	if client.logger == nil || !client.logger.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	client.logger.LogAttrs(context.Background(), slog.LevelDebug, "statsd metric",
+		slog.String("name", name),
+		slog.String("value", m.Value),
+		slog.String("type", m.Type),
+		slog.Any("tags", tags))
+}
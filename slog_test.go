@@ -0,0 +1,59 @@
+package statsd
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recordHandler keeps the records it handles at or above level.
+type recordHandler struct {
+	level   slog.Level
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(_ context.Context, level slog.Level) bool { return level >= h.level }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler               { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler                    { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestWithSlog(t *testing.T) {
+	h := &recordHandler{level: slog.LevelDebug}
+	client, _ := newTestClient(WithTags("env:prod"), WithSlog(slog.New(h)))
+	client.Timing("foo", 12)
+	if len(h.records) != 1 {
+		t.Fatalf("%d records logged, want 1", len(h.records))
+	}
+	r := h.records[0]
+	if r.Level != slog.LevelDebug || r.Message != "statsd metric" {
+		t.Errorf("logged %v %q", r.Level, r.Message)
+	}
+	attrs := map[string]interface{}{}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.Any()
+		return true
+	})
+	want := map[string]interface{}{"name": "foo", "value": "12", "type": "ms", "tags": []string{"env:prod"}}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attributes = %v, want %v", attrs, want)
+	}
+}
+
+func TestWithSlogDebugOff(t *testing.T) {
+	h := &recordHandler{level: slog.LevelInfo}
+	client, sink := newTestClient(WithSlog(slog.New(h)))
+	client.Increment("foo")
+	expectLines(t, sink, "foo:1|c")
+	if len(h.records) != 0 {
+		t.Errorf("%d records logged with debug off", len(h.records))
+	}
+}
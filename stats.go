@@ -0,0 +1,132 @@
+package statsd
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNotConnected = errors.New("statsd: not connected")
+
+// stats counts what happened to the updates passed to the client.
+type stats struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (s *stats) add(key string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[string]int64)
+	}
+	s.counts[key] += n
+}
+
+// written records the outcome of writing one datagram, size bytes of newline
+// delimited lines.
+func (s *stats) written(packet string, size int, err error) {
+	lines := strings.Split(packet, "\n")
+	if err != nil {
+		s.add("errors", 1)
+		s.add("dropped", int64(len(lines)))
+		s.add("deadletter", int64(len(lines)))
+		return
+	}
+	s.add("sent", int64(len(lines)))
+	s.add("bytes", int64(size))
+	for _, line := range lines {
+		t := metricType(line)
+		s.add("sent."+t, 1)
+		s.add("bytes."+t, int64(len(line)))
+	}
+}
+
+/**
+ * Returns a copy of the client's own counters, suitable for feeding a
+ * Prometheus collector or any other self-monitoring:
+ *
+ *     emitted.<t>   updates passed to the client, per statsd type, before
+ *                   sampling or any other filtering
+ *     sent          updates written to the daemon
+ *     sent.<t>      updates written, per statsd type (sent.c, sent.ms, ...)
+ *     bytes         bytes written, headers and newlines between updates included
+ *     bytes.<t>     bytes of the updates written, per statsd type
+ *     dropped       updates sampled out, skipped or lost to write errors
+ *     errors        failed datagram writes
+ *     retries       write attempts repeated by WithRetry
+ *     deadletter    updates lost to write errors, after any retries
+ *     tags.dropped  tags cut by WithMaxTags
+ *     throttled.<n> updates of name n dropped by WithNameRateLimit, counted
+ *                   as throttled.other beyond maxNames throttled names
+ *     queue.full    datagrams dropped by WithBestEffort
+ *     expired       updates dropped by WithQueueTimeout
+ *     oversize      oversized updates dropped or trimmed by WithOversizePolicy
+ *     reserved      updates dropped by WithReservedPrefixes
+ *     invalid       updates of unknown type dropped by UpdateStats
+ *     lint.mismatch updates sent with another type than first, see WithTypeLint
+ *
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Increment("foo")
+ * client.Snapshot()["emitted.c"] // 1
+ **/
+func (client *StatsdClient) Snapshot() map[string]int64 {
+	client.stats.mu.Lock()
+	defer client.stats.mu.Unlock()
+	snapshot := make(map[string]int64, len(client.stats.counts))
+	for key, count := range client.stats.counts {
+		snapshot[key] = count
+	}
+	return snapshot
+}
+
+// errorLog throttles error logging to one line per interval.
+type errorLog struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+/**
+ * Logs at most one write or connection error per interval instead of every
+ * one, so an outage does not flood the logs. The next line logged after
+ * suppressed errors reports how many were suppressed
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithErrorLogInterval(time.Minute))
+ **/
+func WithErrorLogInterval(interval time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.errorLog = &errorLog{interval: interval}
+	}
+}
+
+// logError logs a write or dial error, subject to WithErrorLogInterval.
+func (client *StatsdClient) logError(err error) {
+	l := client.errorLog
+	if l == nil {
+		log.Println(err)
+		return
+	}
+	now := client.clock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() && now.Sub(l.last) < l.interval {
+		l.suppressed++
+		return
+	}
+	if l.suppressed > 0 {
+		log.Printf("%v (%d more errors suppressed)", err, l.suppressed)
+	} else {
+		log.Println(err)
+	}
+	l.last, l.suppressed = now, 0
+}
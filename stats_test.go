@@ -0,0 +1,124 @@
+package statsd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	client, _ := newTestClient(WithSampleFunc(func(stat string) bool { return stat != "skipped" }))
+	client.Increment("foo")
+	client.Increment("foo")
+	client.Gauge("bar", 1)
+	client.Timing("baz", 2)
+	client.Increment("skipped")
+	snapshot := client.Snapshot()
+	want := map[string]int64{
+		"emitted.c":  3,
+		"emitted.g":  1,
+		"emitted.ms": 1,
+		"sent":       4,
+		"sent.c":     2,
+		"sent.g":     1,
+		"sent.ms":    1,
+		"dropped":    1,
+	}
+	for key, count := range want {
+		if snapshot[key] != count {
+			t.Errorf("Snapshot()[%q] = %d, want %d", key, snapshot[key], count)
+		}
+	}
+	if snapshot["bytes"] != int64(len("foo:1|c")*2+len("bar:1|g")+len("baz:2|ms")) {
+		t.Errorf("Snapshot()[\"bytes\"] = %d", snapshot["bytes"])
+	}
+}
+
+func TestSnapshotCountsWriteErrors(t *testing.T) {
+	client := NewWithWriter(failingWriter{})
+	if err := client.SendWait(map[string]string{"foo": "1|c"}, 1); err == nil {
+		t.Fatal("SendWait did not report the write error")
+	}
+	snapshot := client.Snapshot()
+	if snapshot["errors"] != 1 || snapshot["dropped"] != 1 || snapshot["sent"] != 0 {
+		t.Fatalf("Snapshot() = %v", snapshot)
+	}
+}
+
+func TestSnapshotCountsPerType(t *testing.T) {
+	client, _ := newTestClient()
+	client.Increment("a")
+	client.Decrement("b")
+	client.Gauge("c", 1)
+	client.Timing("d", 1)
+	client.Set("e", "x")
+	client.Set("e", "y")
+	client.Set("e", "z")
+	snapshot := client.Snapshot()
+	for key, want := range map[string]int64{
+		"emitted.c": 2, "emitted.g": 1, "emitted.ms": 1, "emitted.s": 3,
+		"sent.c": 2, "sent.g": 1, "sent.ms": 1, "sent.s": 3,
+	} {
+		if snapshot[key] != want {
+			t.Errorf("Snapshot()[%q] = %d, want %d", key, snapshot[key], want)
+		}
+	}
+}
+
+func TestWithErrorLogInterval(t *testing.T) {
+	logged := captureLog(t)
+	clock := newFakeClock()
+	client := NewWithWriter(failingWriter{}, withClock(clock), WithErrorLogInterval(time.Minute))
+	for i := 0; i < 100; i++ {
+		client.Increment("foo")
+	}
+	if logged.String() != "write failed\n" {
+		t.Fatalf("logged %q, want one line", logged)
+	}
+	clock.advance(time.Minute)
+	client.Increment("foo")
+	if want := "write failed\nwrite failed (99 more errors suppressed)\n"; logged.String() != want {
+		t.Fatalf("logged %q, want %q", logged, want)
+	}
+}
+
+func TestWithErrorLogIntervalCoversDialErrors(t *testing.T) {
+	logged := captureLog(t)
+	dialer := &fakeDialer{err: errors.New("connection refused")}
+	client := New("statsd.local", 8125, withDialer(dialer), WithErrorLogInterval(time.Minute))
+	for i := 0; i < 10; i++ {
+		client.Increment("foo")
+	}
+	if logged.String() != "connection refused\n" {
+		t.Fatalf("logged %q, want one line", logged)
+	}
+}
+
+func TestSnapshotCountsBytes(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithBuffering(10), WithPacketHeader([]byte("route:a\n")))
+	client.Increment("foo")
+	client.Gauge("bar", 12)
+	client.Timing("baz", 345)
+	client.Flush()
+	client.Increment("qux")
+	client.Flush()
+	snapshot := client.Snapshot()
+	written := 0
+	for _, packet := range sink.written() {
+		written += len(packet)
+	}
+	if snapshot["bytes"] != int64(written) {
+		t.Errorf("Snapshot()[\"bytes\"] = %d, want the %d bytes written", snapshot["bytes"], written)
+	}
+	want := map[string]int64{
+		"bytes.c":  int64(len("foo:1|c") + len("qux:1|c")),
+		"bytes.g":  int64(len("bar:12|g")),
+		"bytes.ms": int64(len("baz:345|ms")),
+	}
+	for key, count := range want {
+		if snapshot[key] != count {
+			t.Errorf("Snapshot()[%q] = %d, want %d", key, snapshot[key], count)
+		}
+	}
+}
@@ -2,190 +2,1461 @@ package statsd
 
 import (
 	"net"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"log/slog"
+	"math"
 	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
 type StatsdClient struct {
 	Host string
 	Port int
 	conn net.Conn
+
+	socketPath  string
+	connections int
+	dns         DNSPolicy
+	ring        []net.Conn
+	nextConn    int
+
+	packetConn net.PacketConn
+	addr       net.Addr
+	writer     io.Writer
+
+	prefix        string
+	typePrefixes  map[string]string
+	typeRules     []TypeRule
+	sep           string
+	shadow        *shadow
+	skipZero      bool
+	omitRate      map[string]bool
+	header        string
+	delimiters    []string
+	sampleFunc    func(stat string) bool
+	nameLimiter   *nameLimiter
+	counting      *countingSampler
+	upscaler      *upscaler
+	lint          *typeLint
+	hash          func(stat string) uint32
+	sequence      *int64
+	defaultTags   []string
+	maxTags       int
+	tagReplacer   *strings.Replacer
+	lifecycleTags []string
+	contextTags   []contextTag
+	zeroRate      ZeroRatePolicy
+	oversize      OversizePolicy
+	maxLine       int
+	unknownTypes  bool
+	zeroRateOnce  sync.Once
+	normalize     bool
+	nameCase      func(string) string
+	reserved      []string
+	reservedRemap string
+	gaugeRounding Rounding
+	timingUnit    time.Duration
+	closeTimeout  time.Duration
+	writeTimeout  time.Duration
+	deadlines     int32
+	deadlineMu    sync.Mutex // held from setting a write deadline to the write
+	retries       int
+	retryBackoff  time.Duration
+	queueTimeout  time.Duration
+	queueWait     time.Duration
+	errorLog      *errorLog
+	logger        *slog.Logger
+
+	refreshInterval time.Duration
+	openedAt        time.Time
+	dialing         bool // set under mu while refresh reconnects
+	idleTimeout     time.Duration
+	lastWrite       time.Time
+	dial            func(network, address string) (net.Conn, error)
+	now             func() time.Time
+	readMemStats    func(*runtime.MemStats)
+	readFile        func(name string) ([]byte, error)
+	hostname        func() (string, error)
+	hostOnce        sync.Once
+	hostName        string // resolved by host, once
+	templateDefault string
+
+	mu         sync.Mutex
+	rng        *rand.Rand
+	totals     map[string]int64
+	gauges     map[string]int
+	quit       chan struct{}
+	closeOnce  sync.Once
+	closed     bool // set under mu once shutdown closed the connection
+	workers    sync.WaitGroup
+	buffer     *buffer
+	onFlush    func(metrics int, bytes int)
+	maxLines   int
+	packing    bool
+	counters   *counterWindows
+	rates      *counterRates
+	started    *startEpoch
+	async      *asyncWriter
+	reservoirs *reservoirs
+	digests    *digests
+	stats      stats
+
+	middleware []Middleware
+}
+
+/**
+ * Option configures a client at construction time, see New
+ **/
+type Option func(*StatsdClient)
+
+/**
+ * Seeds the random source used for sampling decisions, so that the same
+ * sequence of calls always samples the same metrics (useful for
+ * reproducible load tests)
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithSeed(42))
+ **/
+func WithSeed(seed int64) Option {
+	return func(client *StatsdClient) {
+		client.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+/**
+ * Prepends a prefix to every metric name
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithPrefix("app"))
+ * client.Increment("foo") // app.foo
+ **/
+func WithPrefix(prefix string) Option {
+	return func(client *StatsdClient) {
+		client.prefix = prefix
+	}
+}
+
+/**
+ * Prepends a prefix to metric names of one type ("c", "g", "ms", ...),
+ * applied after the global prefix
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithPrefix("app"),
+ *     statsd.WithTypePrefix("c", "count"),
+ *     statsd.WithTypePrefix("ms", "timing"))
+ * client.Increment("foo") // app.count.foo
+ * client.Timing("foo", 5) // app.timing.foo
+ **/
+func WithTypePrefix(metric string, prefix string) Option {
+	return func(client *StatsdClient) {
+		if client.typePrefixes == nil {
+			client.typePrefixes = make(map[string]string)
+		}
+		client.typePrefixes[metric] = prefix
+	}
+}
+
+/**
+ * Mirrors every metric to a second statsd daemon in shadow mode. Shadow
+ * sends happen on a background goroutine; their failures are never logged
+ * or reported to the caller, only counted (see ShadowFailures). Disabled
+ * in test mode, see TestModeEnv
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithShadow('newhost', 8125))
+ **/
+func WithShadow(host string, port int) Option {
+	return func(client *StatsdClient) {
+		var w io.WriteCloser
+		if conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port))); err == nil {
+			w = conn
+		}
+		client.shadow = newShadow(w)
+	}
+}
+
+/**
+ * Sets the separator placed between prefixes and name components,
+ * "." by default
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithPrefix("app"), statsd.WithSeparator("_"))
+ * client.Increment("db_queries") // app_db_queries
+ **/
+func WithSeparator(sep string) Option {
+	return func(client *StatsdClient) {
+		client.sep = sep
+	}
+}
+
+/**
+ * Cleans up metric names built from several components: runs of separators
+ * are collapsed to one and leading or trailing separators are removed
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithNormalizedNames())
+ * client.Increment("app..foo.") // app.foo
+ **/
+func WithNormalizedNames() Option {
+	return func(client *StatsdClient) {
+		client.normalize = true
+	}
+}
+
+/**
+ * Lowercases every metric name, after prefixes are applied and before
+ * normalization, so mixed case call sites do not split series
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithLowercaseNames())
+ * client.Increment("MyApp.Foo") // myapp.foo
+ **/
+func WithLowercaseNames() Option {
+	return func(client *StatsdClient) {
+		client.nameCase = strings.ToLower
+	}
+}
+
+/**
+ * Uppercases every metric name, like WithLowercaseNames
+ **/
+func WithUppercaseNames() Option {
+	return func(client *StatsdClient) {
+		client.nameCase = strings.ToUpper
+	}
+}
+
+/**
+ * Guards names reserved by the daemon, such as "statsd." for its own
+ * metrics. Updates whose final name (after prefixes) starts with one of
+ * prefixes are dropped and counted as "reserved" in Snapshot, unless
+ * WithReservedRemap is set
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithReservedPrefixes("statsd."))
+ * client.Increment("statsd.foo") // dropped
+ **/
+func WithReservedPrefixes(prefixes ...string) Option {
+	return func(client *StatsdClient) {
+		client.reserved = append(client.reserved, prefixes...)
+	}
+}
+
+/**
+ * Sends updates with a name reserved by WithReservedPrefixes with remap and
+ * the separator prepended, instead of dropping them
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithReservedPrefixes("statsd."),
+ *     statsd.WithReservedRemap("app"))
+ * client.Increment("statsd.foo") // app.statsd.foo
+ **/
+func WithReservedRemap(remap string) Option {
+	return func(client *StatsdClient) {
+		client.reservedRemap = remap
+	}
+}
+
+/**
+ * Gives every datagram write timeout to complete, on connections and
+ * writers that support write deadlines, after which it fails with a
+ * timeout error instead of blocking. The context-aware methods
+ * (IncrementCtx, ...) use the context deadline instead, when it has one.
+ * As a deadline applies to the whole connection, writes are serialized
+ * once any deadline is in use
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithWriteTimeout(50*time.Millisecond))
+ **/
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.writeTimeout = timeout
+	}
+}
+
+/**
+ * Retries a failed datagram write up to retries more times, waiting
+ * backoff before the first retry and doubling the wait before each next
+ * one. Retries are counted as "retries" in Snapshot; updates still not
+ * written after the last attempt are counted as "deadletter", so
+ * operators can alert on permanently lost updates
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithRetry(3, 10*time.Millisecond))
+ **/
+func WithRetry(retries int, backoff time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.retries = retries
+		client.retryBackoff = backoff
+	}
+}
+
+/**
+ * Re-resolves the daemon address and reconnects every interval, so long
+ * lived clients follow DNS changes of the statsd endpoint
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('statsd.local', 8125, statsd.WithRefreshInterval(5*time.Minute))
+ **/
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.refreshInterval = interval
+	}
+}
+
+/**
+ * Closes the connection once nothing was written to it for timeout, so a
+ * client that goes quiet for hours does not keep a socket an intermediary
+ * may have silently dropped. The next write reopens it. A goroutine,
+ * stopped by Close, checks for idleness a few times per timeout
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithIdleTimeout(time.Hour))
+ **/
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.idleTimeout = timeout
+		if timeout > 0 {
+			client.background(client.closeWhenIdle)
+		}
+	}
+}
+
+/**
+ * Drops counter updates with a zero value instead of sending "foo:0|c".
+ * By default zero counters are sent
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithSkipZeroCounters())
+ * client.IncrementByValue("foo", 0) // nothing is sent
+ **/
+func WithSkipZeroCounters() Option {
+	return func(client *StatsdClient) {
+		client.skipZero = true
+	}
+}
+
+/**
+ * Stops appending the "|@rate" suffix to sampled updates of the given
+ * metric types, for daemons that should not scale those types. Sampling
+ * itself still applies
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithoutSampleRateSuffix("ms"))
+ * client.TimingWithSampleRate("foo", 5, 0.5) // foo:5|ms when sampled
+ **/
+func WithoutSampleRateSuffix(metrics ...string) Option {
+	return func(client *StatsdClient) {
+		if client.omitRate == nil {
+			client.omitRate = make(map[string]bool)
+		}
+		for _, metric := range metrics {
+			client.omitRate[metric] = true
+		}
+	}
+}
+
+/**
+ * Prepends a fixed sequence of bytes to every datagram, e.g. a routing
+ * header required by a custom collector. In buffered mode the header counts
+ * against MaxPacketSize
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithPacketHeader([]byte("route:a\n")))
+ **/
+func WithPacketHeader(header []byte) Option {
+	return func(client *StatsdClient) {
+		client.header = string(header)
+	}
+}
+
+/**
+ * Makes sampling consistent: whether a sampled update is kept depends only
+ * on its stat name (hash(stat)/2^32 compared with the rate), so a given
+ * name is either always or never sent at a given rate, here and in any
+ * other service using the same hash. A nil hash means 32 bit FNV-1a
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithConsistentSampling(nil))
+ **/
+func WithConsistentSampling(hash func(stat string) uint32) Option {
+	if hash == nil {
+		hash = fnv1a
+	}
+	return func(client *StatsdClient) {
+		client.hash = hash
+	}
+}
+
+func fnv1a(stat string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(stat))
+	return h.Sum32()
+}
+
+/**
+ * Consults keep for every stat (as passed by the caller, before prefixes
+ * are applied) and drops the update when it returns false. Applies in
+ * addition to rate sampling
+ * Usage:
+ *
+ * import "statsd"
+ * import "strings"
+ * client := statsd.New('localhost', 8125, statsd.WithSampleFunc(func(stat string) bool {
+ *     return strings.HasPrefix(stat, "debug.")
+ * }))
+ **/
+func WithSampleFunc(keep func(stat string) bool) Option {
+	return func(client *StatsdClient) {
+		client.sampleFunc = keep
+	}
+}
+
+/**
+ * Attaches tags to every update, using the DogStatsD "|#tag,key:value"
+ * syntax. Like all tags, they are sent with ",", "|", "#" and whitespace
+ * replaced with "_", so they cannot break the line
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithTags("env:prod", "canary"))
+ * client.Increment("foo") // foo:1|c|#env:prod,canary
+ **/
+func WithTags(tags ...string) Option {
+	return func(client *StatsdClient) {
+		client.defaultTags = append(client.defaultTags, tags...)
+	}
+}
+
+/**
+ * Attaches a "schema_version:<version>" tag to every update, so dashboards
+ * can tell updates sent before and after a metric changed meaning apart.
+ * Off unless set
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithSchemaVersion("2"))
+ * client.Increment("foo") // foo:1|c|#schema_version:2
+ **/
+func WithSchemaVersion(version string) Option {
+	return WithTags("schema_version:" + version)
+}
+
+/**
+ * Caps the number of tags sent with a single update; tags beyond the first
+ * max are dropped (and counted as "tags.dropped" in Snapshot) rather than
+ * having the backend reject the whole update
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithMaxTags(8))
+ **/
+func WithMaxTags(max int) Option {
+	return func(client *StatsdClient) {
+		client.maxTags = max
+	}
+}
+
+/**
+ * Replaces old with new in tag values (the part after the first ":") before
+ * sending, for backends that mangle some characters, e.g. dots
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithTags("version:1.2.3"),
+ *     statsd.WithTagValueReplace(".", "_"))
+ * client.Increment("foo") // foo:1|c|#version:1_2_3
+ **/
+func WithTagValueReplace(old string, new string) Option {
+	return func(client *StatsdClient) {
+		client.tagReplacer = strings.NewReplacer(old, new)
+	}
+}
+
+/**
+ * Tags every update with a "seq:N" tag (DogStatsD "|#" syntax) carrying a
+ * per-client counter incremented per update, so a downstream analyzer can
+ * spot lost datagrams. Only updates actually sent take a number: dropped
+ * updates leave no gap, and timings held by WithTimerReservoir are
+ * numbered when flushed. Note that each value is a distinct tag value: only
+ * enable this where the backend does not index tag values, or the series
+ * cardinality grows without bound
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithSequenceTag())
+ * client.Increment("foo") // foo:1|c|#seq:1
+ * client.Increment("foo") // foo:1|c|#seq:2
+ **/
+func WithSequenceTag() Option {
+	return func(client *StatsdClient) {
+		client.sequence = new(int64)
+	}
+}
+
+/**
+ * What to do with updates sent with a sample rate of 0 (or less), which
+ * would otherwise silently drop every one of them
+ **/
+type ZeroRatePolicy int
+
+const (
+	ZeroRateWarn      ZeroRatePolicy = iota // drop, but log a warning once (default)
+	ZeroRateDrop                            // drop silently
+	ZeroRateUnsampled                       // send as if the rate was 1
+)
+
+// knownTypes are the metric types UpdateStats accepts by default.
+var knownTypes = map[string]bool{"c": true, "g": true, "ms": true, "h": true, "s": true, "d": true, "m": true}
+
+/**
+ * Lets UpdateStats send metric types other than the standard c, g, ms, h,
+ * s, d and m as they are, for daemons with extensions of their own
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithUnknownTypes())
+ * client.UpdateStats([]string{"foo"}, 1, 1, "kv") // foo:1|kv
+ **/
+func WithUnknownTypes() Option {
+	return func(client *StatsdClient) {
+		client.unknownTypes = true
+	}
+}
+
+/**
+ * Sets the handling of a zero sample rate
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithZeroSampleRate(statsd.ZeroRateUnsampled))
+ **/
+func WithZeroSampleRate(policy ZeroRatePolicy) Option {
+	return func(client *StatsdClient) {
+		client.zeroRate = policy
+	}
+}
+
+/**
+ * How float values are turned into integers before sending
+ **/
+type Rounding int
+
+const (
+	RoundNone    Rounding = iota // keep the fraction (default)
+	RoundNearest                 // round half away from zero
+	RoundFloor
+	RoundCeil
+)
+
+func (r Rounding) apply(value float64) float64 {
+	switch r {
+	case RoundNearest:
+		return math.Round(value)
+	case RoundFloor:
+		return math.Floor(value)
+	case RoundCeil:
+		return math.Ceil(value)
+	}
+	return value
+}
+
+/**
+ * Rounds float gauges (GaugeFloat, ClockDrift, ...) to integers, for daemons
+ * that only store integer gauges
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithGaugeRounding(statsd.RoundNearest))
+ * client.GaugeFloat("foo", 2.6) // foo:3|g
+ **/
+func WithGaugeRounding(rounding Rounding) Option {
+	return func(client *StatsdClient) {
+		client.gaugeRounding = rounding
+	}
+}
+
+/**
+ * Replaces the standard statsd delimiters for collectors that expect
+ * others: nameValue instead of the ":" between name and value, and field
+ * instead of every "|" (before the type, the sample rate and the tags).
+ * Datagram packing assumes delimiters of one byte, like the standard ones
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithDelimiters("=", ";"))
+ * client.Increment("foo") // foo=1;c
+ **/
+func WithDelimiters(nameValue string, field string) Option {
+	return func(client *StatsdClient) {
+		client.delimiters = []string{nameValue, field}
+	}
+}
+
+/**
+ * Sets the unit TimingAuto converts durations to, time.Millisecond by
+ * default. The type stays "ms", so only change it for daemons configured
+ * to read timers in another unit
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithTimingUnit(time.Microsecond))
+ **/
+func WithTimingUnit(unit time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.timingUnit = unit
+	}
+}
+
+/**
+ * Limits how long Close waits for background goroutines to stop and for
+ * best-effort writes still queued, by default it waits until they are
+ * done, see Shutdown
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125,
+ *     statsd.WithBestEffort(1000),
+ *     statsd.WithCloseTimeout(time.Second))
+ **/
+func WithCloseTimeout(timeout time.Duration) Option {
+	return func(client *StatsdClient) {
+		client.closeTimeout = timeout
+	}
+}
+
+/**
+ * Factory method to initialize udp connection
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ **/
+func New(host string, port int, opts ...Option) *StatsdClient {
+	client := StatsdClient{Host: host, Port: port}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	if !client.testMode() {
+		client.Open()
+	}
+	client.lifecycle("lifecycle.start")
+	return &client
+}
+
+/**
+ * Factory method that does not dial: the connection is opened by the first
+ * write instead, so construction never fails or blocks on DNS even before
+ * the network is ready (an unbuffered client with WithLifecycle still
+ * writes, and so dials, on construction)
+ * Usage:
+ *
+ * import "statsd"
+ * var client = statsd.NewLazy('localhost', 8125)
+ **/
+func NewLazy(host string, port int, opts ...Option) *StatsdClient {
+	client := StatsdClient{Host: host, Port: port}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	client.testMode()
+	client.lifecycle("lifecycle.start")
+	return &client
+}
+
+/**
+ * Factory method for a client writing to a caller supplied PacketConn,
+ * sending every datagram to addr with WriteTo. The caller keeps ownership
+ * of the PacketConn: Close does not close it
+ * Usage:
+ *
+ * import "net"
+ * import "statsd"
+ * pc, _ := net.ListenPacket("udp", ":0")
+ * addr, _ := net.ResolveUDPAddr("udp", "localhost:8125")
+ * client := statsd.NewWithPacketConn(pc, addr)
+ **/
+func NewWithPacketConn(pc net.PacketConn, addr net.Addr, opts ...Option) *StatsdClient {
+	client := StatsdClient{packetConn: pc, addr: addr}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	client.testMode()
+	client.lifecycle("lifecycle.start")
+	return &client
+}
+
+/**
+ * Factory method for a client writing every datagram to w, for custom
+ * sinks (pipes, in-process collectors, ...). The caller keeps ownership of
+ * w: Close does not close it
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.NewWithWriter(sink)
+ **/
+func NewWithWriter(w io.Writer, opts ...Option) *StatsdClient {
+	client := StatsdClient{writer: w}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	client.lifecycle("lifecycle.start")
+	return &client
+}
+
+/**
+ * Factory method for a client writing to a Unix stream (SOCK_STREAM)
+ * socket, as some collectors expose, instead of UDP. Every datagram is
+ * written newline terminated, so the collector can split the stream into
+ * updates. A failed write closes the connection and the next write dials
+ * again, so the client recovers from collector restarts
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.NewUnixStream('/var/run/statsd.sock')
+ **/
+func NewUnixStream(path string, opts ...Option) *StatsdClient {
+	client := StatsdClient{socketPath: path}
+	for _, opt := range opts {
+		opt(&client)
+	}
+	if !client.testMode() {
+		client.Open()
+	}
+	client.lifecycle("lifecycle.start")
+	return &client
+}
+
+/**
+ * Method to open udp connection, called by default client factory. The
+ * socket is created by the net package with close-on-exec set, so children
+ * started with os/exec or syscall.ForkExec do not inherit it
+ **/
+func (client *StatsdClient) Open() {
+	conn, ring := client.connect()
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.setConn(conn, ring)
+}
+
+// connect dials the daemon, applying the DNS policy, and the rest of the
+// ring. It touches no client state, so callers must not hold mu while it
+// waits between DNS retries.
+func (client *StatsdClient) connect() (net.Conn, []net.Conn) {
+	dial := client.dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	network, connectionString := "udp", net.JoinHostPort(client.Host, strconv.Itoa(client.Port))
+	if client.socketPath != "" {
+		network, connectionString = "unix", client.socketPath
+	}
+	conn, err := dial(network, connectionString)
+	for attempt := 0; isDNSError(err) && attempt < client.dns.Retries; attempt++ {
+		time.Sleep(client.dns.Backoff << attempt)
+		conn, err = dial(network, connectionString)
+	}
+	if isDNSError(err) && client.dns.FallbackIP != "" && network == "udp" {
+		client.logError(err)
+		conn, err = dial(network, net.JoinHostPort(client.dns.FallbackIP, strconv.Itoa(client.Port)))
+	}
+	if err != nil {
+		client.logError(err)
+	}
+	var ring []net.Conn
+	if conn != nil && client.connections > 1 {
+		ring = append(ring, conn)
+		for len(ring) < client.connections {
+			extra, err := dial(network, connectionString)
+			if err != nil {
+				client.logError(err)
+				break
+			}
+			ring = append(ring, extra)
+		}
+	}
+	return conn, ring
+}
+
+// setConn switches to a connection and ring made by connect. Callers
+// hold mu.
+func (client *StatsdClient) setConn(conn net.Conn, ring []net.Conn) {
+	client.conn = conn
+	client.ring = ring
+	client.openedAt = client.clock()
 }
 
 /**
- * Factory method to initialize udp connection
+ * How Open handles a failure to resolve the daemon host name, which is
+ * the usual way dialing UDP fails. Failing DNS lookups are retried up to
+ * Retries times, waiting Backoff before the first retry and doubling the
+ * wait before each next one; if the name still does not resolve and
+ * FallbackIP is set, that address is dialed instead. The zero value fails
+ * fast: the error is logged and the next write tries again
+ **/
+type DNSPolicy struct {
+	Retries    int
+	Backoff    time.Duration
+	FallbackIP string
+}
+
+/**
+ * Sets the handling of DNS failures when opening the connection. Note that
+ * Open, and so New or the write that reopens the connection, blocks while
+ * retrying; other writes meanwhile go to the old connection, or are
+ * dropped if there is none
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('statsd.local', 8125, statsd.WithDNSPolicy(statsd.DNSPolicy{
+ *     Retries:    3,
+ *     Backoff:    100*time.Millisecond,
+ *     FallbackIP: "10.0.0.5",
+ * }))
+ **/
+func WithDNSPolicy(policy DNSPolicy) Option {
+	return func(client *StatsdClient) {
+		client.dns = policy
+	}
+}
+
+// isDNSError reports whether err is a failure to resolve a host name.
+func isDNSError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+/**
+ * Opens n connections to the daemon instead of one and spreads writes
+ * over them round-robin, so writers on many goroutines do not all contend
+ * for one socket under heavy load. Close closes all of them
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithConnections(4))
+ **/
+func WithConnections(n int) Option {
+	return func(client *StatsdClient) {
+		client.connections = n
+	}
+}
+
+// closeConn closes the connection and the rest of the ring. Callers hold
+// mu, or are the only user of the client.
+func (client *StatsdClient) closeConn() {
+	closeRing(client.conn, client.ring)
+	client.ring = nil
+}
+
+// closeRing closes conn and the rest of its ring.
+func closeRing(conn net.Conn, ring []net.Conn) {
+	conn.Close()
+	for _, c := range ring {
+		if c != conn {
+			c.Close()
+		}
+	}
+}
+
+/**
+ * Method to close udp connection, see Shutdown; safe to call more than
+ * once and from several goroutines. Waits at most the
+ * WithCloseTimeout timeout for queued updates
+ **/
+func (client *StatsdClient) Close() {
+	client.Shutdown(client.closeTimeout)
+}
+
+/**
+ * Stops the client's background goroutines, flushes buffered state and
+ * closes the connection. It waits up to timeout (zero meaning no limit) in
+ * all for the background goroutines to return and, in best-effort mode,
+ * for the queue to be written, and returns the number of updates that
+ * were dropped because they could not be written in time. Without
+ * best-effort mode writes are synchronous and nothing is dropped, but the
+ * final flush is then bounded only by WithWriteTimeout, not by timeout.
+ * Only the first call, of Shutdown or Close, does anything; later and
+ * concurrent calls wait for it to finish and return 0. A closed client
+ * does not reopen its connection to the daemon: later writes are dropped
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125, statsd.WithBestEffort(1000))
+ * if dropped := client.Shutdown(2*time.Second); dropped > 0 {
+ *     log.Printf("statsd: %d metrics lost on shutdown", dropped)
+ * }
+ **/
+func (client *StatsdClient) Shutdown(timeout time.Duration) int {
+	dropped := 0
+	client.closeOnce.Do(func() {
+		dropped = client.shutdown(timeout)
+	})
+	return dropped
+}
+
+// shutdown is Shutdown without the guard against repeated calls.
+func (client *StatsdClient) shutdown(timeout time.Duration) int {
+	client.mu.Lock()
+	if client.quit != nil {
+		close(client.quit)
+	}
+	client.mu.Unlock()
+	deadline := time.Now().Add(timeout)
+	client.waitWorkers(timeout)
+	client.lifecycle("lifecycle.stop")
+	client.Flush()
+	dropped := 0
+	if client.async != nil {
+		if timeout > 0 {
+			timeout = time.Until(deadline)
+			if timeout <= 0 {
+				timeout = time.Nanosecond // zero would mean no limit
+			}
+		}
+		dropped = client.async.stop(client, timeout)
+	}
+	client.mu.Lock()
+	client.closed = true
+	if client.conn != nil {
+		client.closeConn()
+	}
+	client.mu.Unlock()
+	if client.shadow != nil {
+		client.shadow.close()
+	}
+	return dropped
+}
+
+// waitWorkers waits up to timeout (zero meaning no limit) for the
+// goroutines started by background to return.
+func (client *StatsdClient) waitWorkers(timeout time.Duration) {
+	if timeout <= 0 {
+		client.workers.Wait()
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		client.workers.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+/**
+ * Number of updates that could not be delivered to the shadow daemon
+ **/
+func (client *StatsdClient) ShadowFailures() int64 {
+	if client.shadow == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&client.shadow.failures)
+}
+
+/*
+ * Log timing information (in milliseconds) without sampling
  * Usage:
  *
  * import "statsd"
+ * import "time"
  * client := statsd.New('localhost', 8125)
+ * t1 := time.Now()
+ * expensiveCall()
+ * t2 := time.Now()
+ * duration := int64(t2.Sub(t1)/time.Mi	llisecond)
+ * client.Timing("foo.time", duration)
  **/
-func New(host string, port int) *StatsdClient {
-	client := StatsdClient{Host: host, Port: port}
-	client.Open()
-	return &client
+func (client *StatsdClient) Timing(stat string, time int64) {
+	updateString := fmt.Sprintf("%d|ms", time)
+	stats := map[string]string{stat: updateString}
+	client.Send(stats, 1)
+}
+
+/**
+ * Log timing information (in milliseconds) with sampling
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125)
+ * t1 := time.Now()
+ * expensiveCall()
+ * t2 := time.Now()
+ * duration := int64(t2.Sub(t1)/time.Millisecond)
+ * client.TimingWithSampleRate("foo.time", duration, 0.2)
+ **/
+func (client *StatsdClient) TimingWithSampleRate(stat string, time int64, sampleRate float32) {
+	updateString := fmt.Sprintf("%d|ms", time)
+	stats := map[string]string{stat: updateString}
+	client.Send(stats, sampleRate)
+}
+
+/**
+ * Log timing information given in nanoseconds, sent as fractional
+ * milliseconds so sub-millisecond precision is kept
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.TimingNanos("foo.time", 1500000) // foo.time:1.5|ms
+ **/
+func (client *StatsdClient) TimingNanos(stat string, ns int64) {
+	stats := map[string]string{stat: millis(time.Duration(ns)) + "|ms"}
+	client.Send(stats, 1)
 }
 
 /**
- * Method to open udp connection, called by default client factory
+ * Log timing information from a time.Duration, converted to the configured
+ * timing unit (milliseconds by default, see WithTimingUnit) keeping any
+ * fraction. Prefer it over Timing, whose int64 leaves the unit to the caller
+ * Usage:
+ *
+ * import "statsd"
+ * import "time"
+ * client := statsd.New('localhost', 8125)
+ * t1 := time.Now()
+ * expensiveCall()
+ * client.TimingAuto("foo.time", time.Since(t1))
  **/
-func (client *StatsdClient) Open() {
-	connectionString := fmt.Sprintf("%s:%d", client.Host, client.Port)
-	conn, err := net.Dial("udp", connectionString)
-	if err != nil {
-		log.Println(err)
+func (client *StatsdClient) TimingAuto(stat string, value time.Duration) {
+	unit := client.timingUnit
+	if unit <= 0 {
+		unit = time.Millisecond
 	}
-	client.conn = conn
+	updateString := strconv.FormatFloat(float64(value)/float64(unit), 'f', -1, 64) + "|ms"
+	client.Send(map[string]string{stat: updateString}, 1)
+}
+
+/**
+ * Increments one stat counter without sampling
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Increment('foo.bar')
+ **/
+func (client *StatsdClient) Increment(stat string) {
+	stats := []string{stat}
+	client.UpdateStats(stats, 1, 1, "c")
+}
+
+/**
+ * Increments one stat counter without sampling, only when cond is true
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.IncrementIf(err != nil, 'foo.errors')
+ **/
+func (client *StatsdClient) IncrementIf(cond bool, stat string) {
+	if cond {
+		client.Increment(stat)
+	}
+}
+
+/**
+ * Increments one stat counter with sampling
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Increment('foo.bar', 0.2)
+ **/
+func (client *StatsdClient) IncrementWithSampling(stat string, sampleRate float32) {
+	stats := []string{stat}
+	client.UpdateStats(stats[:], 1, sampleRate, "c")
+}
+
+
+/**
+ * Increments one stat counter by value provided without sampling
+ *
+ * Usage:
+ *
+ *     import "statsd"
+ *     client := statsd.New('localhost', 8125)
+ *     client.IncrementByValue('foo.bar', 5)
+ **/
+func (client *StatsdClient) IncrementByValue(stat string, val int) {
+	stats := []string{stat}
+	client.UpdateStats(stats, val, 1, "c")
+}
+
+/**
+ * Increments the counter named after an enum value, looked up in a name
+ * table indexed by the value. Out of range values are counted under
+ * "unknown"
+ * Usage:
+ *
+ * import "statsd"
+ * const (
+ *     Created = iota
+ *     Deleted
+ * )
+ * var eventNames = []string{"created", "deleted"}
+ * client := statsd.New('localhost', 8125)
+ * client.IncrementEnum("events", Deleted, eventNames) // events.deleted
+ **/
+func (client *StatsdClient) IncrementEnum(stat string, value int, names []string) {
+	name := "unknown"
+	if value >= 0 && value < len(names) {
+		name = names[value]
+	}
+	client.Increment(client.join(stat, name))
+}
+
+// maxTotals bounds the number of stats CountFromTotal remembers.
+const maxTotals = 10000
+
+/**
+ * Counts a stat from an absolute, monotonically increasing total (like a
+ * total request count) by emitting the delta since the previous total.
+ * The first call only records the total. If the total decreased, the
+ * source is assumed to have been reset and the new total is emitted. Once
+ * maxTotals stats are remembered, new stats are not recorded and so never
+ * counted
+ * Usage:
+ *
+ *     import "statsd"
+ *     client := statsd.New('localhost', 8125)
+ *     client.CountFromTotal('requests', 100) // nothing, baseline
+ *     client.CountFromTotal('requests', 130) // requests:30|c
+ *     client.CountFromTotal('requests', 12)  // requests:12|c
+ **/
+func (client *StatsdClient) CountFromTotal(stat string, total int64) {
+	client.mu.Lock()
+	last, seen := client.totals[stat]
+	if client.totals == nil {
+		client.totals = make(map[string]int64)
+	}
+	if seen || len(client.totals) < maxTotals {
+		client.totals[stat] = total
+	}
+	client.mu.Unlock()
+
+	if !seen {
+		return
+	}
+	delta := total - last
+	if delta < 0 {
+		delta = total
+	}
+	client.IncrementByValue(stat, int(delta))
+}
+
+/**
+ * Sends a counter with an explicit "|@rate" suffix, telling the daemon to
+ * scale the value by 1/rate, without sampling on the client: the update is
+ * always sent. Use it for values pre-aggregated from a sample, as opposed
+ * to IncrementWithSampling which drops updates to honour the rate
+ * Usage:
+ *
+ *     import "statsd"
+ *     client := statsd.New('localhost', 8125)
+ *     client.CountWithRate('foo', 5, 0.5) // foo:5|c|@0.5
+ **/
+func (client *StatsdClient) CountWithRate(stat string, val int, rate float32) {
+	updateString := fmt.Sprintf("%d|c|@%s", val, strconv.FormatFloat(float64(rate), 'f', -1, 32))
+	client.Send(map[string]string{stat: updateString}, 1)
+}
+
+/**
+ * Decrements one stat counter without sampling
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Decrement('foo.bar')
+ **/
+func (client *StatsdClient) Decrement(stat string) {
+	stats := []string{stat}
+	client.UpdateStats(stats[:], -1, 1, "c")
+}
+
+/**
+ * Decrements one stat counter by value provided without sampling
+ *
+ * Usage:
+ *
+ *     import "statsd"
+ *     client := statsd.New('localhost', 8125)
+ *     client.DecrementByValue('foo.bar', 3) // foo.bar:-3|c
+ **/
+func (client *StatsdClient) DecrementByValue(stat string, val int) {
+	stats := []string{stat}
+	client.UpdateStats(stats, -val, 1, "c")
+}
+
+/**
+ * Decrements one stat counter by value provided with sampling
+ *
+ * Usage:
+ *
+ *     import "statsd"
+ *     client := statsd.New('localhost', 8125)
+ *     client.DecrementByValueWithSampling('foo.bar', 3, 0.2)
+ **/
+func (client *StatsdClient) DecrementByValueWithSampling(stat string, val int, sampleRate float32) {
+	stats := []string{stat}
+	client.UpdateStats(stats, -val, sampleRate, "c")
+}
+
+/**
+ * Decrements one stat counter with sampling
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Decrement('foo.bar', 0.2)
+ **/
+func (client *StatsdClient) DecrementWithSampling(stat string, sampleRate float32) {
+	stats := []string{stat}
+	client.UpdateStats(stats[:], -1, sampleRate, "c")
+}
+
+func (client *StatsdClient) Counter(stat string, value int) {
+	stats := []string{stat}
+	client.UpdateStats(stats[:], 1, 1, "c")
 }
 
 /**
- * Method to close udp connection
+ * Gauge without sampling
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Gauge('foo.bar', value)
  **/
-func (client *StatsdClient) Close() {
-	client.conn.Close()
+func (client *StatsdClient) Gauge(stat string, value int) {
+	stats := []string{stat}
+	client.UpdateStats(stats[:], value, 1, "g")
 }
 
-/*
- * Log timing information (in milliseconds) without sampling
+/**
+ * Gauge without sampling, tagged with its unit ("unit:<unit>") for
+ * dashboards that format values by unit
  * Usage:
  *
  * import "statsd"
- * import "time"
  * client := statsd.New('localhost', 8125)
- * t1 := time.Now()
- * expensiveCall()
- * t2 := time.Now()
- * duration := int64(t2.Sub(t1)/time.Mi	llisecond)
- * client.Timing("foo.time", duration)
+ * client.GaugeWithUnit('mem', 1024, "bytes") // mem:1024|g|#unit:bytes
  **/
-func (client *StatsdClient) Timing(stat string, time int64) {
-	updateString := fmt.Sprintf("%d|ms", time)
-	stats := map[string]string{stat: updateString}
-	client.Send(stats, 1)
+func (client *StatsdClient) GaugeWithUnit(stat string, value int, unit string) {
+	stats := map[string]string{stat: strconv.Itoa(value) + "|g"}
+	client.dispatch(client.formatTagged(stats, 1, []string{"unit:" + unit}))
 }
 
+// maxGaugeDeltas bounds the number of stats GaugeWithDelta remembers.
+const maxGaugeDeltas = 10000
+
 /**
- * Log timing information (in milliseconds) with sampling
+ * Gauge without sampling that also gauges "<stat>.delta" to the change
+ * since the previous GaugeWithDelta of stat, for dashboards showing both.
+ * The delta is sent like GaugeFloat, so a negative delta is not read as a
+ * relative change. The first value of a stat has no delta; once
+ * maxGaugeDeltas stats are remembered, new stats get none either
  * Usage:
  *
  * import "statsd"
- * import "time"
  * client := statsd.New('localhost', 8125)
- * t1 := time.Now()
- * expensiveCall()
- * t2 := time.Now()
- * duration := int64(t2.Sub(t1)/time.Millisecond)
- * client.TimingWithSampleRate("foo.time", duration, 0.2)
+ * client.GaugeWithDelta('queue', 10) // queue:10|g
+ * client.GaugeWithDelta('queue', 7)  // queue:7|g, queue.delta:0|g, queue.delta:-3|g
  **/
-func (client *StatsdClient) TimingWithSampleRate(stat string, time int64, sampleRate float32) {
-	updateString := fmt.Sprintf("%d|ms", time)
-	stats := map[string]string{stat: updateString}
-	client.Send(stats, sampleRate)
+func (client *StatsdClient) GaugeWithDelta(stat string, value int) {
+	client.mu.Lock()
+	if client.gauges == nil {
+		client.gauges = make(map[string]int)
+	}
+	previous, seen := client.gauges[stat]
+	if seen || len(client.gauges) < maxGaugeDeltas {
+		client.gauges[stat] = value
+	}
+	client.mu.Unlock()
+	client.Gauge(stat, value)
+	if seen {
+		client.GaugeFloat(client.join(stat, "delta"), float64(value-previous))
+	}
 }
 
 /**
- * Increments one stat counter without sampling
+ * Gauges a boolean as 1 for true and 0 for false, such as a feature flag
+ * state or a health check result
  * Usage:
  *
  * import "statsd"
  * client := statsd.New('localhost', 8125)
- * client.Increment('foo.bar')
+ * client.GaugeBool('feature.enabled', true) // feature.enabled:1|g
  **/
-func (client *StatsdClient) Increment(stat string) {
-	stats := []string{stat}
-	client.UpdateStats(stats, 1, 1, "c")
+func (client *StatsdClient) GaugeBool(stat string, b bool) {
+	value := 0
+	if b {
+		value = 1
+	}
+	client.Gauge(stat, value)
 }
 
 /**
- * Increments one stat counter with sampling
+ * Gauge with sampling
  * Usage:
  *
  * import "statsd"
  * client := statsd.New('localhost', 8125)
- * client.Increment('foo.bar', 0.2)
+ * client.Gauge('foo.bar', value, 0.2)
  **/
-func (client *StatsdClient) IncrementWithSampling(stat string, sampleRate float32) {
+func (client *StatsdClient) GaugeWithSampling(stat string, value int, sampleRate float32) {
 	stats := []string{stat}
-	client.UpdateStats(stats[:], 1, sampleRate, "c")
+	client.UpdateStats(stats[:], value, sampleRate, "g")
 }
 
-
 /**
- * Increments one stat counter by value provided without sampling
- *
+ * Adds a value to a set, counting unique occurrences of values between
+ * flushes. Buffered clients send each distinct value once per flush
  * Usage:
  *
- *     import "statsd"
- *     client := statsd.New('localhost', 8125)
- *     client.IncrementByValue('foo.bar', 5)
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Set('users.unique', userID)
  **/
-func (client *StatsdClient) IncrementByValue(stat string, val int) {
-	stats := []string{stat}
-	client.UpdateStats(stats, val, 1, "c")
+func (client *StatsdClient) Set(stat string, value string) {
+	if client.buffer != nil {
+		client.addSet(stat, value)
+		return
+	}
+	client.Send(map[string]string{stat: value + "|s"}, 1)
 }
 
 /**
- * Decrements one stat counter without sampling
+ * Adds a value to a set with sampling. Sampled set values are not
+ * deduplicated by buffered clients
  * Usage:
  *
  * import "statsd"
  * client := statsd.New('localhost', 8125)
- * client.Decrement('foo.bar')
+ * client.SetWithSampleRate('users.unique', userID, 0.2)
  **/
-func (client *StatsdClient) Decrement(stat string) {
-	stats := []string{stat}
-	client.UpdateStats(stats[:], -1, 1, "c")
+func (client *StatsdClient) SetWithSampleRate(stat string, value string, sampleRate float32) {
+	client.Send(map[string]string{stat: value + "|s"}, sampleRate)
 }
 
 /**
- * Decrements one stat counter with sampling
+ * Gauge with a float value, without sampling. A negative value is sent as a
+ * reset to 0 followed by the value, since statsd reads a leading sign as a
+ * relative change. The two lines are one update: filtered together and
+ * written in the same datagram
  * Usage:
  *
  * import "statsd"
  * client := statsd.New('localhost', 8125)
- * client.Decrement('foo.bar', 0.2)
+ * client.GaugeFloat('foo.bar', -1.5) // foo.bar:0|g\nfoo.bar:-1.5|g
  **/
-func (client *StatsdClient) DecrementWithSampling(stat string, sampleRate float32) {
-	stats := []string{stat}
-	client.UpdateStats(stats[:], -1, sampleRate, "c")
-}
-
-func (client *StatsdClient) Counter(stat string, value int) {
-	stats := []string{stat}
-	client.UpdateStats(stats[:], 1, 1, "c")
+func (client *StatsdClient) GaugeFloat(stat string, value float64) {
+	value = client.gaugeRounding.apply(value)
+	if value == 0 {
+		value = 0 // drop the sign of -0, which would read as a relative change
+	}
+	m := Metric{Name: stat, Value: strconv.FormatFloat(value, 'f', -1, 64), Type: "g", SampleRate: 1, reset: value < 0}
+	client.dispatch(client.formatMetric(m))
 }
 
 /**
- * Gauge without sampling
+ * Gauges the difference between the local clock and a reference time (for
+ * example from an NTP synced source) in milliseconds. Positive values mean
+ * the local clock is ahead of the reference
  * Usage:
  *
  * import "statsd"
  * client := statsd.New('localhost', 8125)
- * client.Gauge('foo.bar', value)
+ * client.ClockDrift('clock.drift', ntpTime)
  **/
-func (client *StatsdClient) Gauge(stat string, value int) {
-	stats := []string{stat}
-	client.UpdateStats(stats[:], value, 1, "g")
+func (client *StatsdClient) ClockDrift(stat string, reference time.Time) {
+	drift := client.clock().Sub(reference)
+	client.GaugeFloat(stat, float64(drift)/float64(time.Millisecond))
 }
 
 /**
- * Gauge with sampling
+ * Gauges the age of t, the time elapsed since it, in fractional seconds,
+ * for freshness monitoring. A t in the future, such as one from a host
+ * whose clock is ahead, gauges 0 rather than a negative age
  * Usage:
  *
  * import "statsd"
  * client := statsd.New('localhost', 8125)
- * client.Gauge('foo.bar', value, 0.2)
+ * client.Age('cache.age', lastRefresh)
  **/
-func (client *StatsdClient) GaugeWithSampling(stat string, value int, sampleRate float32) {
-	stats := []string{stat}
-	client.UpdateStats(stats[:], value, sampleRate, "g")
+func (client *StatsdClient) Age(stat string, t time.Time) {
+	age := client.clock().Sub(t)
+	if age < 0 {
+		age = 0
+	}
+	client.GaugeFloat(stat, age.Seconds())
 }
 
 /**
- * Arbitrarily updates a list of stats by a delta
+ * Arbitrarily updates a list of stats by a delta. The stats slice is read
+ * before UpdateStats returns and never retained, so callers may reuse it.
+ * A metric type statsd does not know (see WithUnknownTypes) is logged and
+ * the updates dropped, counted as "dropped" and "invalid" in Snapshot
  **/
 func (client *StatsdClient) UpdateStats(stats []string, delta int, sampleRate float32, metric string) {
+	if !knownTypes[metric] && !client.unknownTypes {
+		client.logError(fmt.Errorf("statsd: unknown metric type %q", metric))
+		client.stats.add("dropped", int64(len(stats)))
+		client.stats.add("invalid", int64(len(stats)))
+		return
+	}
+	if delta == 0 && metric == "c" && client.skipZero {
+		client.stats.add("dropped", int64(len(stats)))
+		return
+	}
+	if metric == "c" && client.rates != nil {
+		client.countRates(stats, delta)
+	}
+	if metric == "c" && sampleRate >= 1 && client.counters != nil {
+		client.Send(client.aggregate(stats, delta), 1)
+		return
+	}
 	statsToSend := make(map[string]string)
 	for _,stat := range stats {
 		updateString := fmt.Sprintf("%d|%s", delta, metric)
@@ -195,27 +1466,566 @@ func (client *StatsdClient) UpdateStats(stats []string, delta int, sampleRate fl
 }
 
 /**
- * Sends data to udp statsd daemon
+ * Sends data to udp statsd daemon, in order of stat name. The data map is
+ * copied before anything else happens and never retained, so callers may
+ * reuse it
  **/
 func (client *StatsdClient) Send(data map[string]string, sampleRate float32) {
-	sampledData := make(map[string]string)
+	client.dispatch(client.format(data, sampleRate))
+}
+
+/**
+ * Like Send, but bypasses buffering and writes every update immediately,
+ * returning the first error reported by the kernel for the write (such as
+ * EMSGSIZE for an oversized datagram or ENOBUFS). A nil error means the
+ * datagrams were accepted by the local network stack, not that the daemon
+ * received them
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * err := client.SendWait(map[string]string{"deploys": "1|c"}, 1)
+ **/
+func (client *StatsdClient) SendWait(data map[string]string, sampleRate float32) error {
+	var firstErr error
+	for _, line := range client.format(data, sampleRate) {
+		if err := client.writeNow(line); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+/**
+ * Formats data exactly like Send (prefixes, tags, sampling, header) but
+ * writes it to w instead of the client's connection, one write per update,
+ * for example to serve several tenants with their own endpoints from one
+ * configured client. Buffering and the shadow backend do not apply
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithPrefix("app"))
+ * err := client.SendTo(tenantConn, map[string]string{"logins": "1|c"}, 1)
+ **/
+func (client *StatsdClient) SendTo(w io.Writer, data map[string]string, sampleRate float32) error {
+	var firstErr error
+	for _, line := range client.format(data, sampleRate) {
+		if _, err := io.WriteString(w, client.datagram(line)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// format samples data and renders the surviving updates as wire lines.
+func (client *StatsdClient) format(data map[string]string, sampleRate float32) []string {
+	return client.formatTagged(data, sampleRate, nil)
+}
+
+// formatTagged is format with extra tags for these updates only.
+func (client *StatsdClient) formatTagged(data map[string]string, sampleRate float32, extra []string) []string {
+	lines := make([]string, 0, len(data))
+	for _, stat := range sortedKeys(data) {
+		m := Metric{Name: stat, SampleRate: sampleRate, Tags: extra[:len(extra):len(extra)]}
+		var rest string
+		m.Value, rest, _ = strings.Cut(data[stat], "|")
+		m.Type, m.fields, _ = strings.Cut(rest, "|")
+		lines = append(lines, client.formatMetric(m)...)
+	}
+	return lines
+}
+
+// formatMetric runs one update through the middleware chain and renders
+// whatever reaches the end of it.
+func (client *StatsdClient) formatMetric(m Metric) []string {
+	client.stats.add("emitted."+m.Type, 1)
+	if client.lint != nil {
+		client.lintType(m.Name, m.Type)
+	}
+	var lines []string
+	client.chain(0, m, func(m Metric) {
+		if line, ok := client.render(m); ok {
+			lines = append(lines, line)
+		}
+	})
+	return lines
+}
+
+// render samples and filters one update and renders it as a wire line.
+func (client *StatsdClient) render(m Metric) (string, bool) {
+	sampleRate := m.SampleRate
+	if sampleRate <= 0 {
+		switch client.zeroRate {
+		case ZeroRateUnsampled:
+			sampleRate = 1
+		case ZeroRateWarn:
+			client.zeroRateOnce.Do(func() {
+				log.Printf("statsd: sample rate %v drops every update", sampleRate)
+			})
+			fallthrough
+		default:
+			client.stats.add("dropped", 1)
+			return "", false
+		}
+	}
+	if client.typeRules != nil {
+		m.Type = client.remapType(m.Name, m.Type)
+	}
+	v := m.Value + "|" + m.Type
+	if m.fields != "" {
+		v += "|" + m.fields
+	}
 	if sampleRate < 1 {
-		r := rand.New(rand.NewSource(time.Now().Unix()))
-		if rNum := r.Float32(); rNum <= sampleRate {
-			for stat,value := range data {
-				sampledUpdateString := fmt.Sprintf("%s|@%f", value, sampleRate)
-				sampledData[stat] = sampledUpdateString
+		if rNum := client.sampleValue(m.Name); rNum > sampleRate {
+			client.stats.add("dropped", 1)
+			return "", false
+		}
+		if up, ok := client.upscale(m, sampleRate); ok {
+			v = up
+		} else if !client.omitRate[m.Type] {
+			v = fmt.Sprintf("%s|@%f", v, sampleRate)
+		}
+	}
+
+	k := m.Name
+	if client.sampleFunc != nil && !client.sampleFunc(k) {
+		client.stats.add("dropped", 1)
+		return "", false
+	}
+	if client.counting != nil {
+		var keep bool
+		if v, keep = client.counting.sample(k, v); !keep {
+			client.stats.add("dropped", 1)
+			return "", false
+		}
+	}
+	if client.nameLimiter != nil && !client.nameLimiter.allow(k, client.clock()) {
+		client.stats.add("dropped", 1)
+		client.stats.add(client.nameLimiter.throttledKey(k), 1)
+		return "", false
+	}
+	if client.digests != nil && m.Type == "ms" && sampleRate >= 1 {
+		if value, err := strconv.ParseFloat(m.Value, 64); err == nil {
+			client.digests.add(k, value)
+			return "", false
+		}
+	}
+	name := client.qualify(k, m.Type)
+	for _, prefix := range client.reserved {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if client.reservedRemap == "" {
+			client.stats.add("dropped", 1)
+			client.stats.add("reserved", 1)
+			return "", false
+		}
+		name = client.join(client.reservedRemap, name)
+		break
+	}
+	update_string := fmt.Sprintf("%s:%s", name, v)
+	tags := client.tags(m.Tags)
+	line, ok := client.fit(update_string, tags)
+	if !ok {
+		return "", false
+	}
+	if client.reservoirs != nil && m.Type == "ms" && sampleRate >= 1 {
+		client.reservoirs.add(name, update_string, line[len(update_string):], client.random)
+		return "", false
+	}
+	line = client.sequenced(line)
+	if m.reset && m.Type == "g" && strings.HasPrefix(m.Value, "-") {
+		line = name + ":0|g" + line[len(update_string):] + "\n" + line
+	}
+	client.mirror(name, m, tags)
+	return line, true
+}
+
+// dispatch buffers lines in buffered mode and writes them one datagram per
+// line, or packed with WithPacking, otherwise.
+func (client *StatsdClient) dispatch(lines []string) {
+	if client.packing && client.buffer == nil && len(lines) > 1 {
+		client.flushLines(lines)
+		return
+	}
+	for _, line := range lines {
+		if client.buffer != nil {
+			client.enqueue(line)
+			continue
+		}
+		client.write(line)
+	}
+}
+
+// write sends one datagram to the daemon and its shadow, returning the
+// result of the write to the daemon. In best-effort mode the datagram is
+// queued for the background writer instead.
+func (client *StatsdClient) write(packet string) error {
+	if client.async != nil {
+		return client.async.enqueue(client, packet)
+	}
+	return client.writeNow(packet)
+}
+
+// writeNow is write without the best-effort queue.
+func (client *StatsdClient) writeNow(packet string) error {
+	return client.writeBy(packet, time.Time{})
+}
+
+// writeBy is writeNow with a write deadline overriding WithWriteTimeout,
+// unless zero.
+func (client *StatsdClient) writeBy(packet string, deadline time.Time) error {
+	datagram := client.datagram(packet)
+	err := client.transmit(datagram, deadline)
+	for attempt := 0; err != nil && attempt < client.retries; attempt++ {
+		client.stats.add("retries", 1)
+		time.Sleep(client.retryBackoff << attempt)
+		err = client.transmit(datagram, deadline)
+	}
+	if err != nil && err != errNotConnected {
+		client.logError(err)
+	}
+	client.stats.written(packet, len(datagram), err)
+	if client.shadow != nil {
+		client.shadow.send(datagram)
+	}
+	return err
+}
+
+// transmit makes one attempt at writing a datagram to the daemon.
+func (client *StatsdClient) transmit(datagram string, deadline time.Time) error {
+	if deadline.IsZero() && client.writeTimeout > 0 {
+		deadline = time.Now().Add(client.writeTimeout)
+	}
+	if !deadline.IsZero() {
+		atomic.StoreInt32(&client.deadlines, 1)
+	}
+	if atomic.LoadInt32(&client.deadlines) != 0 {
+		// the deadline holds for every write on the connection, so a
+		// concurrent write must not replace it before this one is done
+		client.deadlineMu.Lock()
+		defer client.deadlineMu.Unlock()
+	}
+	var err error
+	if client.writer != nil {
+		client.setDeadline(client.writer, deadline)
+		_, err = io.WriteString(client.writer, datagram)
+	} else if client.packetConn != nil {
+		client.setDeadline(client.packetConn, deadline)
+		_, err = client.packetConn.WriteTo([]byte(datagram), client.addr)
+	} else if conn := client.refresh(); conn != nil {
+		client.setDeadline(conn, deadline)
+		if client.socketPath != "" {
+			if _, err = io.WriteString(conn, datagram+"\n"); err != nil {
+				client.dropConn(conn)
 			}
+		} else {
+			_, err = fmt.Fprint(conn, datagram)
+		}
+		if err == nil && client.idleTimeout > 0 {
+			client.mu.Lock()
+			client.lastWrite = client.clock()
+			client.mu.Unlock()
 		}
 	} else {
-		sampledData = data
+		// Open already logged why there is no connection, or Close ran
+		err = errNotConnected
+	}
+	return err
+}
+
+// setDeadline sets the write deadline of w, if it has one. Once any
+// deadline was set, a zero deadline clears it again for writes without.
+// Called by transmit with deadlineMu held once deadlines are in use.
+func (client *StatsdClient) setDeadline(w interface{}, deadline time.Time) {
+	d, ok := w.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok || atomic.LoadInt32(&client.deadlines) == 0 {
+		return
+	}
+	d.SetWriteDeadline(deadline)
+}
+
+// datagram renders lines as they go on the wire: with the packet header
+// and the configured delimiters.
+func (client *StatsdClient) datagram(packet string) string {
+	if client.delimiters != nil {
+		lines := strings.Split(packet, "\n")
+		for i, line := range lines {
+			if name, rest, ok := strings.Cut(line, ":"); ok {
+				lines[i] = name + client.delimiters[0] + strings.ReplaceAll(rest, "|", client.delimiters[1])
+			}
+		}
+		packet = strings.Join(lines, "\n")
+	}
+	return client.header + packet
+}
+
+// sampleValue returns the value compared with the sample rate for an update
+// of stat: random, or derived from the name with consistent sampling.
+func (client *StatsdClient) sampleValue(stat string) float32 {
+	if client.hash == nil {
+		return client.random()
+	}
+	return float32(float64(client.hash(stat)) / (1 << 32))
+}
+
+// random returns the next sampling value from the client's random source,
+// creating a time-seeded one for clients built without New.
+func (client *StatsdClient) random() float32 {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.rng == nil {
+		client.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return client.rng.Float32()
+}
+
+// qualify applies the global and per-type prefixes to a stat name.
+func (client *StatsdClient) qualify(stat string, metric string) string {
+	if prefix, ok := client.typePrefixes[metric]; ok && prefix != "" {
+		stat = client.join(prefix, stat)
+	}
+	if client.prefix != "" {
+		stat = client.join(client.prefix, stat)
+	}
+	if client.nameCase != nil {
+		stat = client.nameCase(stat)
+	}
+	if client.normalize {
+		stat = client.normalizeName(stat)
+	}
+	return stat
+}
+
+// normalizeName collapses repeated separators and trims them from both ends.
+func (client *StatsdClient) normalizeName(stat string) string {
+	sep := client.separator()
+	parts := strings.Split(stat, sep)
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, sep)
+}
+
+/**
+ * Builds a metric name from components, joined with the configured
+ * separator. Characters that would break the wire format (":", "|", "@",
+ * "#", ",", whitespace) or the separator itself are replaced with "_" in
+ * each component
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Increment(client.Name("app", "db", "query")) // app.db.query
+ * client.Name("app", "host:1.2") // app.host_1_2
+ **/
+func (client *StatsdClient) Name(parts ...string) string {
+	sep := client.separator()
+	clean := make([]string, len(parts))
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, sep, "_")
+		clean[i] = strings.Map(func(r rune) rune {
+			switch {
+			case r == ':', r == '|', r == '@', r == '#', r == ',', unicode.IsSpace(r):
+				return '_'
+			}
+			return r
+		}, part)
+	}
+	return strings.Join(clean, sep)
+}
+
+// tags returns the tags attached to the next update: the client's tags
+// followed by extra, each cleaned by cleanTag.
+func (client *StatsdClient) tags(extra []string) []string {
+	tags := append([]string(nil), client.defaultTags...)
+	tags = append(tags, extra...)
+	for i, tag := range tags {
+		tags[i] = cleanTag(tag)
+	}
+	if client.tagReplacer != nil {
+		for i, tag := range tags {
+			if key, value, ok := strings.Cut(tag, ":"); ok {
+				tags[i] = key + ":" + client.tagReplacer.Replace(value)
+			}
+		}
+	}
+	if client.sequence != nil {
+		tags = append(tags, seqPlaceholder)
+	}
+	if client.maxTags > 0 && len(tags) > client.maxTags {
+		client.stats.add("tags.dropped", int64(len(tags)-client.maxTags))
+		tags = tags[:client.maxTags]
+	}
+	return tags
+}
+
+// cleanTag replaces the characters that would end a tag or the update
+// (",", "|", "#", whitespace) with "_".
+func cleanTag(tag string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ',', r == '|', r == '#', unicode.IsSpace(r):
+			return '_'
+		}
+		return r
+	}, tag)
+}
+
+// seqPlaceholder stands in for the WithSequenceTag tag, at the size of the
+// largest one, until the update is known to be sent: a number is only
+// taken from the counter then, so dropped or held updates leave no gap.
+var seqPlaceholder = "seq:" + strings.Repeat("\x00", 19)
+
+// sequenced replaces the seq placeholder in line, if any, with the next
+// value of the sequence counter.
+func (client *StatsdClient) sequenced(line string) string {
+	if client.sequence == nil || !strings.Contains(line, seqPlaceholder) {
+		return line
+	}
+	seq := "seq:" + strconv.FormatInt(atomic.AddInt64(client.sequence, 1), 10)
+	return strings.Replace(line, seqPlaceholder, seq, 1)
+}
+
+// join concatenates name components with the configured separator.
+func (client *StatsdClient) join(parts ...string) string {
+	return strings.Join(parts, client.separator())
+}
+
+func (client *StatsdClient) separator() string {
+	if client.sep == "" {
+		return "."
 	}
+	return client.sep
+}
+
+// metricType extracts the type from an update string such as "1|c|@0.5".
+func metricType(value string) string {
+	parts := strings.SplitN(value, "|", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// refresh returns the connection to write to, opening it if an earlier
+// Open failed and reconnecting once the refresh interval has elapsed, or
+// nil once the client is closed. Only one write reconnects at a time,
+// without holding mu while dialing.
+func (client *StatsdClient) refresh() net.Conn {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closed {
+		return nil
+	}
+	stale := client.conn == nil ||
+		client.refreshInterval > 0 && client.clock().Sub(client.openedAt) >= client.refreshInterval ||
+		client.idle()
+	if stale && !client.dialing {
+		client.dialing = true
+		client.mu.Unlock()
+		conn, ring := client.connect()
+		client.mu.Lock()
+		client.dialing = false
+		if client.closed {
+			// Close ran while dialing: do not leak the new connection
+			if conn != nil {
+				closeRing(conn, ring)
+			}
+			return nil
+		}
+		if client.conn != nil {
+			client.closeConn()
+		}
+		client.setConn(conn, ring)
+	}
+	if len(client.ring) > 0 {
+		client.nextConn++
+		return client.ring[client.nextConn%len(client.ring)]
+	}
+	return client.conn
+}
+
+// idle reports whether the connection outlived the idle timeout since it
+// was opened or last written to. Callers hold mu.
+func (client *StatsdClient) idle() bool {
+	if client.idleTimeout <= 0 {
+		return false
+	}
+	last := client.lastWrite
+	if client.openedAt.After(last) {
+		last = client.openedAt
+	}
+	return client.clock().Sub(last) >= client.idleTimeout
+}
 
-	for k, v := range sampledData {
-		update_string := fmt.Sprintf("%s:%s", k, v)
-		_,err := fmt.Fprintf(client.conn, update_string)
-		if err != nil {
-			log.Println(err)
+// dropConn closes a stream connection a write failed on, unless it was
+// replaced already, so the next write dials again.
+func (client *StatsdClient) dropConn(conn net.Conn) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.conn == conn {
+		client.closeConn()
+		client.conn = nil
+	}
+}
+
+// closeIdle closes the connection if it is idle; the next write reopens it.
+func (client *StatsdClient) closeIdle() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.conn != nil && client.idle() {
+		client.closeConn()
+		client.conn = nil
+	}
+}
+
+// closeWhenIdle runs closeIdle a few times per idle timeout until quit is
+// closed.
+func (client *StatsdClient) closeWhenIdle(quit <-chan struct{}) {
+	tick := client.idleTimeout / 4
+	if tick <= 0 {
+		tick = client.idleTimeout
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			client.closeIdle()
+		case <-quit:
+			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+func (client *StatsdClient) clock() time.Time {
+	if client.now == nil {
+		return time.Now()
+	}
+	return client.now()
+}
+
+// background runs fn on a goroutine that Close stops, by closing quit, and
+// waits for before closing the connection.
+func (client *StatsdClient) background(fn func(quit <-chan struct{})) {
+	client.mu.Lock()
+	if client.quit == nil {
+		client.quit = make(chan struct{})
+	}
+	quit := client.quit
+	client.workers.Add(1)
+	client.mu.Unlock()
+	go func() {
+		defer client.workers.Done()
+		fn(quit)
+	}()
+}
+
+// millis formats a duration as fractional milliseconds.
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
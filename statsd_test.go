@@ -0,0 +1,1072 @@
+package statsd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client writing to a fresh in-memory sink.
+func newTestClient(opts ...Option) (*StatsdClient, *MemorySink) {
+	sink := &MemorySink{}
+	return NewWithWriter(sink, opts...), sink
+}
+
+// expectLines fails the test unless sink recorded exactly want.
+func expectLines(t *testing.T, sink *MemorySink, want ...string) {
+	t.Helper()
+	if got := sink.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
+// eventually fails the test unless cond becomes true within a second.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("write failed") }
+func (failingWriter) Close() error                { return nil }
+
+// datagrams records every write as one datagram.
+type datagrams struct {
+	mu      sync.Mutex
+	packets []string
+}
+
+func (d *datagrams) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.packets = append(d.packets, string(p))
+	return len(p), nil
+}
+
+func (d *datagrams) written() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.packets...)
+}
+
+// fakeConn is a connection recording what is written to it.
+type fakeConn struct {
+	net.Conn
+	sink   MemorySink
+	closed int32 // number of Close calls
+}
+
+func (c *fakeConn) Write(p []byte) (int, error)      { return c.sink.Write(p) }
+func (c *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *fakeConn) Close() error {
+	atomic.AddInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *fakeConn) isClosed() bool { return atomic.LoadInt32(&c.closed) > 0 }
+
+// fakeDialer hands out fakeConns, or fails with err while it is set; the
+// next fails times only, if fails is set.
+type fakeDialer struct {
+	mu    sync.Mutex
+	err   error
+	fails int
+	addrs []string
+	conns []*fakeConn
+}
+
+func (d *fakeDialer) dial(network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.addrs = append(d.addrs, address)
+	if err := d.err; err != nil {
+		if d.fails > 0 {
+			if d.fails--; d.fails == 0 {
+				d.err = nil
+			}
+		}
+		return nil, err
+	}
+	conn := &fakeConn{}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *fakeDialer) dialed() []*fakeConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*fakeConn(nil), d.conns...)
+}
+
+func withDialer(d *fakeDialer) Option {
+	return func(client *StatsdClient) {
+		client.dial = d.dial
+	}
+}
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func withClock(c *fakeClock) Option {
+	return func(client *StatsdClient) {
+		client.now = c.now
+	}
+}
+
+func TestWithSeedIsReproducible(t *testing.T) {
+	run := func() []string {
+		client, sink := newTestClient(WithSeed(42))
+		for i := 0; i < 200; i++ {
+			client.IncrementWithSampling(fmt.Sprintf("foo.%d", i), 0.5)
+		}
+		return sink.Lines()
+	}
+	first, second := run(), run()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("same seed sampled differently:\n%q\n%q", first, second)
+	}
+	if len(first) == 0 || len(first) == 200 {
+		t.Fatalf("sampled %d of 200 updates at rate 0.5", len(first))
+	}
+}
+
+func TestWithTypePrefix(t *testing.T) {
+	client, sink := newTestClient(
+		WithPrefix("app"),
+		WithTypePrefix("c", "count"),
+		WithTypePrefix("ms", "timing"))
+	client.Increment("foo")
+	client.Timing("foo", 5)
+	client.Gauge("foo", 1)
+	expectLines(t, sink, "app.count.foo:1|c", "app.timing.foo:5|ms", "app.foo:1|g")
+}
+
+func TestShadowFailuresDoNotAffectPrimary(t *testing.T) {
+	client, sink := newTestClient()
+	client.shadow = newShadow(failingWriter{})
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		if err := client.SendWait(map[string]string{"foo": "1|c"}, 1); err != nil {
+			t.Fatalf("SendWait: %v", err)
+		}
+	}
+	expectLines(t, sink, "foo:1|c", "foo:1|c", "foo:1|c")
+	eventually(t, func() bool { return client.ShadowFailures() == 3 })
+}
+
+func TestWithRefreshIntervalReconnects(t *testing.T) {
+	dialer, clock := &fakeDialer{}, newFakeClock()
+	client := New("statsd.local", 8125, withDialer(dialer), withClock(clock),
+		WithRefreshInterval(time.Minute))
+	defer client.Close()
+	client.Increment("foo")
+	clock.advance(30 * time.Second)
+	client.Increment("foo")
+	if n := len(dialer.dialed()); n != 1 {
+		t.Fatalf("dialed %d times before the interval, want 1", n)
+	}
+	clock.advance(31 * time.Second)
+	client.Increment("bar")
+	conns := dialer.dialed()
+	if len(conns) != 2 {
+		t.Fatalf("dialed %d times after the interval, want 2", len(conns))
+	}
+	if !conns[0].isClosed() {
+		t.Error("old connection not closed on refresh")
+	}
+	expectLines(t, &conns[0].sink, "foo:1|c", "foo:1|c")
+	expectLines(t, &conns[1].sink, "bar:1|c")
+}
+
+func TestIncrementEnum(t *testing.T) {
+	names := []string{"created", "updated", "deleted"}
+	client, sink := newTestClient()
+	client.IncrementEnum("events", 0, names)
+	client.IncrementEnum("events", 2, names)
+	client.IncrementEnum("events", 3, names)
+	client.IncrementEnum("events", -1, names)
+	expectLines(t, sink, "events.created:1|c", "events.deleted:1|c",
+		"events.unknown:1|c", "events.unknown:1|c")
+}
+
+func TestCountFromTotal(t *testing.T) {
+	client, sink := newTestClient()
+	for _, total := range []int64{100, 130, 130, 175, 12, 20} {
+		client.CountFromTotal("requests", total)
+	}
+	expectLines(t, sink, "requests:30|c", "requests:0|c", "requests:45|c",
+		"requests:12|c", "requests:8|c")
+}
+
+func TestCountFromTotalIsBounded(t *testing.T) {
+	client, sink := newTestClient()
+	for i := 0; i < maxTotals+10; i++ {
+		client.CountFromTotal(fmt.Sprintf("requests.%d", i), 1)
+	}
+	if len(client.totals) != maxTotals {
+		t.Errorf("%d totals remembered, want %d", len(client.totals), maxTotals)
+	}
+	client.CountFromTotal("requests.0", 3)
+	client.CountFromTotal(fmt.Sprintf("requests.%d", maxTotals), 3)
+	expectLines(t, sink, "requests.0:2|c")
+}
+
+func TestWithSkipZeroCounters(t *testing.T) {
+	client, sink := newTestClient(WithSkipZeroCounters())
+	client.IncrementByValue("foo", 0)
+	client.Gauge("bar", 0)
+	client.IncrementByValue("foo", 2)
+	expectLines(t, sink, "bar:0|g", "foo:2|c")
+
+	client, sink = newTestClient()
+	client.IncrementByValue("foo", 0)
+	expectLines(t, sink, "foo:0|c")
+}
+
+func TestWithSeparator(t *testing.T) {
+	client, sink := newTestClient(WithPrefix("app"), WithSeparator("_"))
+	client.Increment(client.Name("db", "queries"))
+	client.IncrementEnum("events", 0, []string{"created"})
+	expectLines(t, sink, "app_db_queries:1|c", "app_events_created:1|c")
+}
+
+func TestTimingNanos(t *testing.T) {
+	client, sink := newTestClient()
+	client.TimingNanos("foo.time", 1_500_000)
+	client.TimingNanos("foo.time", 250)
+	expectLines(t, sink, "foo.time:1.5|ms", "foo.time:0.00025|ms")
+}
+
+// keepAll makes every sampling decision keep the update.
+func keepAll() Option {
+	return WithConsistentSampling(func(string) uint32 { return 0 })
+}
+
+func TestWithoutSampleRateSuffix(t *testing.T) {
+	client, sink := newTestClient(keepAll(), WithoutSampleRateSuffix("ms"))
+	client.IncrementWithSampling("foo", 0.5)
+	client.TimingWithSampleRate("foo", 5, 0.5)
+	expectLines(t, sink, "foo:1|c|@0.500000", "foo:5|ms")
+}
+
+func TestSendReopensFailedConnection(t *testing.T) {
+	dialer := &fakeDialer{err: errors.New("connection refused")}
+	client := New("statsd.local", 8125, withDialer(dialer))
+	defer client.Close()
+	if err := client.SendWait(map[string]string{"foo": "1|c"}, 1); err == nil {
+		t.Fatal("SendWait succeeded without a connection")
+	}
+	dialer.mu.Lock()
+	dialer.err = nil
+	dialer.mu.Unlock()
+	if err := client.SendWait(map[string]string{"foo": "2|c"}, 1); err != nil {
+		t.Fatalf("SendWait after the server came up: %v", err)
+	}
+	conns := dialer.dialed()
+	if len(conns) != 1 {
+		t.Fatalf("dialed %d connections, want 1", len(conns))
+	}
+	expectLines(t, &conns[0].sink, "foo:2|c")
+}
+
+func TestWithPacketHeader(t *testing.T) {
+	header := "route:a\n"
+	sink := &datagrams{}
+	client := NewWithWriter(sink, WithPacketHeader([]byte(header)), WithBuffering(1000))
+	for i := 0; i < 200; i++ {
+		client.Increment(fmt.Sprintf("foo.%d", i))
+	}
+	client.Gauge("bar", 1)
+	client.Flush()
+	packets := sink.written()
+	if len(packets) < 2 {
+		t.Fatalf("wrote %d datagrams, want the buffer split", len(packets))
+	}
+	for _, packet := range packets {
+		if !strings.HasPrefix(packet, header) {
+			t.Errorf("datagram %q does not start with the header", packet)
+		}
+		if len(packet) > MaxPacketSize {
+			t.Errorf("datagram of %d bytes exceeds MaxPacketSize", len(packet))
+		}
+	}
+}
+
+// listen opens a UDP listener on the loopback interface.
+func listen(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// receive reads one datagram from pc, failing the test after a second.
+func receive(t *testing.T, pc net.PacketConn) string {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNewWithPacketConn(t *testing.T) {
+	target, pc := listen(t), listen(t)
+	client := NewWithPacketConn(pc, target.LocalAddr())
+	client.Increment("foo")
+	if got := receive(t, target); got != "foo:1|c" {
+		t.Fatalf("received %q, want foo:1|c", got)
+	}
+	client.Close()
+	if _, err := pc.WriteTo([]byte("bar:1|c"), target.LocalAddr()); err != nil {
+		t.Fatalf("Close closed the caller's PacketConn: %v", err)
+	}
+}
+
+func TestWithSampleFunc(t *testing.T) {
+	client, sink := newTestClient(keepAll(), WithPrefix("app"),
+		WithSampleFunc(func(stat string) bool { return strings.HasPrefix(stat, "debug.") }))
+	client.Increment("debug.foo")
+	client.Increment("foo")
+	client.IncrementWithSampling("debug.bar", 0.5)
+	client.IncrementWithSampling("bar", 0.5)
+	expectLines(t, sink, "app.debug.foo:1|c", "app.debug.bar:1|c|@0.500000")
+}
+
+func TestUpdateStatsDoesNotRetainInput(t *testing.T) {
+	sink := &MemorySink{}
+	client := NewWithWriter(sink, WithBestEffort(100), WithBuffering(2),
+		WithCounterRates())
+	stats := []string{"foo", "bar"}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.UpdateStats(stats, 1, 1, "c")
+		}()
+	}
+	wg.Wait()
+	client.UpdateStats(stats, 1, 1, "c")
+	stats[0], stats[1] = "mutated", "mutated"
+	client.Close()
+	for _, line := range sink.Lines() {
+		if strings.Contains(line, "mutated") {
+			t.Fatalf("emitted the caller's later change: %q", line)
+		}
+	}
+}
+
+func TestClockDrift(t *testing.T) {
+	clock := newFakeClock()
+	sink := &datagrams{}
+	client := NewWithWriter(sink, withClock(clock))
+	client.ClockDrift("clock.drift", clock.now().Add(-250*time.Millisecond))
+	client.ClockDrift("clock.drift", clock.now().Add(1500*time.Millisecond))
+	want := []string{"clock.drift:250|g", "clock.drift:0|g\nclock.drift:-1500|g"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+}
+
+func TestNegativeGaugeIsOneUpdate(t *testing.T) {
+	sink := &datagrams{}
+	calls := 0
+	client := NewWithWriter(sink, WithTags("env:prod"),
+		WithSampleFunc(func(stat string) bool { return stat != "dropped" }))
+	client.Use(func(m Metric, next func(Metric)) {
+		calls++
+		next(m)
+	})
+	client.GaugeFloat("foo", -1.5)
+	client.GaugeFloat("dropped", -1.5)
+	if calls != 2 {
+		t.Errorf("middleware ran %d times for two gauges", calls)
+	}
+	want := []string{"foo:0|g|#env:prod\nfoo:-1.5|g|#env:prod"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+}
+
+func TestWithSequenceTag(t *testing.T) {
+	client, sink := newTestClient(WithSequenceTag(), WithTags("env:prod"))
+	client.Increment("foo")
+	client.Increment("foo")
+	client.Gauge("bar", 1)
+	expectLines(t, sink, "foo:1|c|#env:prod,seq:1", "foo:1|c|#env:prod,seq:2", "bar:1|g|#env:prod,seq:3")
+}
+
+func TestWithSequenceTagSkipsUnsentUpdates(t *testing.T) {
+	client, sink := newTestClient(WithSequenceTag(), WithTimerReservoir(1),
+		WithOversizePolicy(OversizeDrop), WithMaxLineLength(40))
+	client.Increment("foo")
+	client.Increment(strings.Repeat("x", 40))
+	client.Timing("held", 5)
+	client.Increment("bar")
+	client.Flush()
+	expectLines(t, sink, "foo:1|c|#seq:1", "bar:1|c|#seq:2", "held:5|ms|#seq:3")
+}
+
+func TestWithNormalizedNames(t *testing.T) {
+	client, sink := newTestClient(WithNormalizedNames(), WithPrefix("app."))
+	client.Increment(".foo.")
+	client.Increment("bar..baz")
+	expectLines(t, sink, "app.foo:1|c", "app.bar.baz:1|c")
+
+	client, sink = newTestClient(WithNormalizedNames())
+	client.Increment("app..foo.")
+	expectLines(t, sink, "app.foo:1|c")
+}
+
+func TestDecrementByValue(t *testing.T) {
+	client, sink := newTestClient(keepAll())
+	client.DecrementByValue("foo", 3)
+	client.DecrementByValueWithSampling("foo", 2, 0.5)
+	expectLines(t, sink, "foo:-3|c", "foo:-2|c|@0.500000")
+}
+
+func TestWithMaxTags(t *testing.T) {
+	client, sink := newTestClient(WithTags("a:1", "b:2"), WithMaxTags(3))
+	client.GaugeWithUnit("foo", 1, "bytes")
+	client.Send(map[string]string{"bar": "1|c"}, 1)
+	client.dispatch(client.formatTagged(map[string]string{"baz": "1|c"}, 1, []string{"c:3", "d:4"}))
+	expectLines(t, sink, "foo:1|g|#a:1,b:2,unit:bytes", "bar:1|c|#a:1,b:2", "baz:1|c|#a:1,b:2,c:3")
+	if dropped := client.Snapshot()["tags.dropped"]; dropped != 1 {
+		t.Errorf("tags.dropped = %d, want 1", dropped)
+	}
+}
+
+func TestSendWaitReturnsKernelErrors(t *testing.T) {
+	target := listen(t)
+	client := New("127.0.0.1", target.LocalAddr().(*net.UDPAddr).Port)
+	defer client.Close()
+	if err := client.SendWait(map[string]string{"foo": "1|c"}, 1); err != nil {
+		t.Fatalf("SendWait: %v", err)
+	}
+	err := client.SendWait(map[string]string{"foo": strings.Repeat("1", 70000) + "|c"}, 1)
+	if !errors.Is(err, syscall.EMSGSIZE) {
+		t.Fatalf("SendWait of an oversized datagram returned %v, want EMSGSIZE", err)
+	}
+}
+
+func TestName(t *testing.T) {
+	client, _ := newTestClient()
+	if got := client.Name("app", "db", "query"); got != "app.db.query" {
+		t.Errorf("Name = %q, want app.db.query", got)
+	}
+	if got := client.Name("app", "host:1.2", "a b|c@d#e,f"); got != "app.host_1_2.a_b_c_d_e_f" {
+		t.Errorf("Name = %q, want app.host_1_2.a_b_c_d_e_f", got)
+	}
+	client, _ = newTestClient(WithSeparator("_"))
+	if got := client.Name("app", "db_pool"); got != "app_db_pool" {
+		t.Errorf("Name = %q, want app_db_pool", got)
+	}
+}
+
+func TestSendTo(t *testing.T) {
+	client, sink := newTestClient(WithPrefix("app"), WithBuffering(10))
+	tenantA, tenantB := &MemorySink{}, &MemorySink{}
+	data := map[string]string{"logins": "1|c"}
+	if err := client.SendTo(tenantA, data, 1); err != nil {
+		t.Fatalf("SendTo: %v", err)
+	}
+	if err := client.SendTo(tenantB, data, 1); err != nil {
+		t.Fatalf("SendTo: %v", err)
+	}
+	client.Close()
+	expectLines(t, tenantA, "app.logins:1|c")
+	expectLines(t, tenantB, "app.logins:1|c")
+	expectLines(t, sink)
+	if err := client.SendTo(failingWriter{}, data, 1); err == nil {
+		t.Error("SendTo did not return the write error")
+	}
+}
+
+// captureLog redirects the standard logger to a buffer for the rest of
+// the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	flags := log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestZeroSampleRate(t *testing.T) {
+	logged := captureLog(t)
+	client, sink := newTestClient()
+	client.IncrementWithSampling("foo", 0)
+	client.IncrementWithSampling("foo", 0)
+	expectLines(t, sink)
+	if n := strings.Count(logged.String(), "drops every update"); n != 1 {
+		t.Errorf("warned %d times, want once: %q", n, logged)
+	}
+
+	client, sink = newTestClient(WithZeroSampleRate(ZeroRateDrop))
+	logged.Reset()
+	client.IncrementWithSampling("foo", 0)
+	expectLines(t, sink)
+	if logged.Len() != 0 {
+		t.Errorf("ZeroRateDrop logged %q", logged)
+	}
+
+	client, sink = newTestClient(WithZeroSampleRate(ZeroRateUnsampled))
+	client.IncrementWithSampling("foo", 0)
+	expectLines(t, sink, "foo:1|c")
+}
+
+func TestWithTagValueReplace(t *testing.T) {
+	client, sink := newTestClient(WithTags("version:1.2.3", "host.name:a.b"), WithTagValueReplace(".", "_"))
+	client.Increment("foo.bar")
+	expectLines(t, sink, "foo.bar:1|c|#version:1_2_3,host.name:a_b")
+}
+
+func TestCountWithRate(t *testing.T) {
+	client, sink := newTestClient()
+	for i := 0; i < 10; i++ {
+		client.CountWithRate("foo", 5, 0.5)
+	}
+	if lines := sink.Lines(); len(lines) != 10 || lines[0] != "foo:5|c|@0.5" {
+		t.Fatalf("lines = %q, want ten foo:5|c|@0.5", lines)
+	}
+}
+
+func TestWithGaugeRounding(t *testing.T) {
+	for rounding, want := range map[Rounding][]string{
+		RoundNone:    {"foo:2.6|g", "foo:0|g\nfoo:-2.5|g"},
+		RoundNearest: {"foo:3|g", "foo:0|g\nfoo:-3|g"},
+		RoundFloor:   {"foo:2|g", "foo:0|g\nfoo:-3|g"},
+		RoundCeil:    {"foo:3|g", "foo:0|g\nfoo:-2|g"},
+	} {
+		sink := &datagrams{}
+		client := NewWithWriter(sink, WithGaugeRounding(rounding))
+		client.GaugeFloat("foo", 2.6)
+		client.GaugeFloat("foo", -2.5)
+		if got := sink.written(); !reflect.DeepEqual(got, want) {
+			t.Errorf("rounding %d: datagrams = %q, want %q", rounding, got, want)
+		}
+	}
+}
+
+func TestNewLazyDialsOnFirstSend(t *testing.T) {
+	dialer := &fakeDialer{}
+	client := NewLazy("statsd.local", 8125, withDialer(dialer))
+	defer client.Close()
+	if n := len(dialer.dialed()); n != 0 {
+		t.Fatalf("NewLazy dialed %d times", n)
+	}
+	client.Increment("foo")
+	conns := dialer.dialed()
+	if len(conns) != 1 {
+		t.Fatalf("dialed %d times on the first send, want 1", len(conns))
+	}
+	expectLines(t, &conns[0].sink, "foo:1|c")
+}
+
+func TestNewLazyWithServerStartedLater(t *testing.T) {
+	client := NewLazy("127.0.0.1", 0)
+	defer client.Close()
+	target := listen(t)
+	client.Port = target.LocalAddr().(*net.UDPAddr).Port
+	if err := client.SendWait(map[string]string{"foo": "1|c"}, 1); err != nil {
+		t.Fatalf("SendWait: %v", err)
+	}
+	if got := receive(t, target); got != "foo:1|c" {
+		t.Fatalf("received %q, want foo:1|c", got)
+	}
+}
+
+func TestWithDelimiters(t *testing.T) {
+	sink := &datagrams{}
+	client := NewWithWriter(sink, keepAll(), WithDelimiters("=", ";"), WithBuffering(10))
+	client.Increment("foo")
+	client.TimingWithSampleRate("bar", 5, 0.5)
+	client.Flush()
+	want := []string{"foo=1;c\nbar=5;ms;@0.500000"}
+	if got := sink.written(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("datagrams = %q, want %q", got, want)
+	}
+}
+
+func TestIncrementIf(t *testing.T) {
+	client, sink := newTestClient()
+	client.IncrementIf(true, "errors")
+	client.IncrementIf(false, "errors")
+	expectLines(t, sink, "errors:1|c")
+}
+
+func TestTimingAuto(t *testing.T) {
+	client, sink := newTestClient()
+	client.TimingAuto("foo", 2*time.Second)
+	client.TimingAuto("foo", 1500*time.Microsecond)
+	client.TimingAuto("foo", 250*time.Nanosecond)
+	expectLines(t, sink, "foo:2000|ms", "foo:1.5|ms", "foo:0.00025|ms")
+
+	client, sink = newTestClient(WithTimingUnit(time.Microsecond))
+	client.TimingAuto("foo", 1500*time.Microsecond)
+	expectLines(t, sink, "foo:1500|ms")
+}
+
+func TestWithLowercaseNames(t *testing.T) {
+	client, sink := newTestClient(WithPrefix("MyApp"), WithLowercaseNames())
+	client.Increment("Foo")
+	expectLines(t, sink, "myapp.foo:1|c")
+
+	client, sink = newTestClient(WithUppercaseNames(), WithNormalizedNames())
+	client.Increment("MyApp..Foo")
+	expectLines(t, sink, "MYAPP.FOO:1|c")
+}
+
+func TestWithCountingSampler(t *testing.T) {
+	client, sink := newTestClient(WithCountingSampler(5, false))
+	for i := 0; i < 10; i++ {
+		client.Increment("foo")
+		client.Timing("bar", int64(i))
+	}
+	expectLines(t, sink, "foo:1|c", "bar:4|ms", "foo:1|c", "bar:9|ms")
+
+	client, sink = newTestClient(WithCountingSampler(5, true))
+	for i := 0; i < 10; i++ {
+		client.IncrementByValue("foo", 2)
+	}
+	expectLines(t, sink, "foo:10|c", "foo:10|c")
+}
+
+func TestSetWithSampleRate(t *testing.T) {
+	client, sink := newTestClient(keepAll())
+	client.SetWithSampleRate("users", "alice", 0.25)
+	expectLines(t, sink, "users:alice|s|@0.250000")
+
+	client, sink = newTestClient(WithConsistentSampling(func(string) uint32 { return math.MaxUint32 }))
+	client.SetWithSampleRate("users", "alice", 0.25)
+	expectLines(t, sink)
+}
+
+func TestWithReservedPrefixes(t *testing.T) {
+	client, sink := newTestClient(WithReservedPrefixes("statsd."))
+	client.Increment("statsd.foo")
+	client.Increment("foo")
+	expectLines(t, sink, "foo:1|c")
+	if reserved := client.Snapshot()["reserved"]; reserved != 1 {
+		t.Errorf("reserved = %d, want 1", reserved)
+	}
+
+	client, sink = newTestClient(WithReservedPrefixes("statsd."), WithReservedRemap("app"))
+	client.Increment("statsd.foo")
+	expectLines(t, sink, "app.statsd.foo:1|c")
+}
+
+func TestGaugeWithUnit(t *testing.T) {
+	client, sink := newTestClient(WithTags("env:prod"))
+	client.GaugeWithUnit("mem", 1024, "bytes")
+	expectLines(t, sink, "mem:1024|g|#env:prod,unit:bytes")
+}
+
+func TestWithConsistentSampling(t *testing.T) {
+	hashes := map[string]uint32{"low": 1 << 30, "high": 3 << 30}
+	client, sink := newTestClient(WithConsistentSampling(func(stat string) uint32 { return hashes[stat] }))
+	for i := 0; i < 5; i++ {
+		client.IncrementWithSampling("low", 0.5)
+		client.IncrementWithSampling("high", 0.5)
+		client.IncrementWithSampling("high", 0.8)
+	}
+	lines := sink.Lines()
+	if len(lines) != 10 || lines[0] != "low:1|c|@0.500000" || lines[1] != "high:1|c|@0.800000" {
+		t.Fatalf("lines = %q, want low kept at 0.5 and high only at 0.8", lines)
+	}
+
+	client, sink = newTestClient(WithConsistentSampling(nil))
+	for i := 0; i < 5; i++ {
+		client.IncrementWithSampling("foo", float32(fnv1a("foo"))/(1<<32)+0.001)
+	}
+	if n := len(sink.Lines()); n != 5 {
+		t.Fatalf("default FNV hash kept %d of 5 updates above its value", n)
+	}
+}
+
+func TestWithIdleTimeout(t *testing.T) {
+	dialer, clock := &fakeDialer{}, newFakeClock()
+	client := New("statsd.local", 8125, withDialer(dialer), withClock(clock), WithIdleTimeout(time.Minute))
+	defer client.Close()
+	client.Increment("foo")
+	clock.advance(50 * time.Second)
+	client.Increment("foo")
+	clock.advance(50 * time.Second)
+	client.closeIdle()
+	if conns := dialer.dialed(); len(conns) != 1 || conns[0].isClosed() {
+		t.Fatal("connection closed although written to within the idle timeout")
+	}
+	clock.advance(10 * time.Second)
+	client.closeIdle()
+	conns := dialer.dialed()
+	if !conns[0].isClosed() {
+		t.Fatal("idle connection not closed")
+	}
+	client.Increment("bar")
+	conns = dialer.dialed()
+	if len(conns) != 2 {
+		t.Fatalf("dialed %d times, want a reopen on the next send", len(conns))
+	}
+	expectLines(t, &conns[0].sink, "foo:1|c", "foo:1|c")
+	expectLines(t, &conns[1].sink, "bar:1|c")
+}
+
+func TestWithConnectionsSpreadsWrites(t *testing.T) {
+	dialer := &fakeDialer{}
+	client := New("statsd.local", 8125, withDialer(dialer), WithConnections(4))
+	for i := 0; i < 40; i++ {
+		client.Increment("foo")
+	}
+	conns := dialer.dialed()
+	if len(conns) != 4 {
+		t.Fatalf("dialed %d connections, want 4", len(conns))
+	}
+	for i, conn := range conns {
+		if n := len(conn.sink.Lines()); n != 10 {
+			t.Errorf("connection %d got %d of 40 writes, want 10", i, n)
+		}
+	}
+	client.Close()
+	for i, conn := range conns {
+		if !conn.isClosed() {
+			t.Errorf("connection %d not closed", i)
+		}
+	}
+}
+
+func BenchmarkConnections(b *testing.B) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pc.Close()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("connections=%d", n), func(b *testing.B) {
+			client := New("127.0.0.1", port, WithConnections(n))
+			defer client.Close()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					client.Increment("foo")
+				}
+			})
+		})
+	}
+}
+
+func TestUpdateStatsUnknownType(t *testing.T) {
+	logged := captureLog(t)
+	client, sink := newTestClient()
+	client.UpdateStats([]string{"foo", "bar"}, 1, 1, "zzz")
+	client.UpdateStats([]string{"foo"}, 1, 1, "h")
+	expectLines(t, sink, "foo:1|h")
+	if snapshot := client.Snapshot(); snapshot["invalid"] != 2 || snapshot["dropped"] != 2 {
+		t.Errorf("Snapshot() = %v, want 2 invalid", snapshot)
+	}
+	if !strings.Contains(logged.String(), `unknown metric type "zzz"`) {
+		t.Errorf("logged %q", logged)
+	}
+
+	client, sink = newTestClient(WithUnknownTypes())
+	client.UpdateStats([]string{"foo"}, 1, 1, "kv")
+	expectLines(t, sink, "foo:1|kv")
+}
+
+func TestGaugeWithDelta(t *testing.T) {
+	client, sink := newTestClient()
+	client.GaugeWithDelta("queue", 10)
+	client.GaugeWithDelta("queue", 7)
+	client.GaugeWithDelta("queue", 9)
+	expectLines(t, sink, "queue:10|g", "queue:7|g", "queue.delta:0|g", "queue.delta:-3|g",
+		"queue:9|g", "queue.delta:2|g")
+}
+
+func TestGaugeWithDeltaIsBounded(t *testing.T) {
+	client, sink := newTestClient()
+	for i := 0; i < maxGaugeDeltas+10; i++ {
+		client.GaugeWithDelta(fmt.Sprintf("queue.%d", i), 1)
+	}
+	if len(client.gauges) != maxGaugeDeltas {
+		t.Errorf("%d gauges remembered, want %d", len(client.gauges), maxGaugeDeltas)
+	}
+	sink.Reset()
+	client.GaugeWithDelta(fmt.Sprintf("queue.%d", maxGaugeDeltas), 2)
+	expectLines(t, sink, fmt.Sprintf("queue.%d:2|g", maxGaugeDeltas))
+}
+
+func TestWithSchemaVersion(t *testing.T) {
+	client, sink := newTestClient(WithSchemaVersion("2"))
+	client.Increment("foo")
+	client.Timing("bar", 5)
+	expectLines(t, sink, "foo:1|c|#schema_version:2", "bar:5|ms|#schema_version:2")
+}
+
+func TestAge(t *testing.T) {
+	clock := newFakeClock()
+	client, sink := newTestClient(withClock(clock))
+	client.Age("cache.age", clock.now().Add(-90*time.Second))
+	client.Age("cache.age", clock.now().Add(-1500*time.Millisecond))
+	client.Age("cache.age", clock.now().Add(time.Minute))
+	expectLines(t, sink, "cache.age:90|g", "cache.age:1.5|g", "cache.age:0|g")
+}
+
+// flakyWriter fails the first failures writes, then writes to sink.
+type flakyWriter struct {
+	failures int
+	attempts int
+	sink     MemorySink
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.attempts++
+	if w.attempts <= w.failures {
+		return 0, errors.New("write failed")
+	}
+	return w.sink.Write(p)
+}
+
+func TestWithRetry(t *testing.T) {
+	w := &flakyWriter{failures: 2}
+	client := NewWithWriter(w, WithRetry(3, time.Microsecond))
+	client.Increment("foo")
+	expectLines(t, &w.sink, "foo:1|c")
+	if snapshot := client.Snapshot(); snapshot["retries"] != 2 || snapshot["deadletter"] != 0 {
+		t.Errorf("Snapshot() = %v, want 2 retries and no dead letters", snapshot)
+	}
+}
+
+func TestWithRetryCountsDeadLetters(t *testing.T) {
+	captureLog(t)
+	client := NewWithWriter(failingWriter{}, WithRetry(2, time.Microsecond),
+		WithFlushPolicy(FlushPolicy{Count: 2}))
+	client.Increment("foo")
+	client.Increment("bar")
+	client.Increment("baz")
+	if snapshot := client.Snapshot(); snapshot["retries"] != 2 || snapshot["deadletter"] != 2 {
+		t.Errorf("Snapshot() = %v, want 2 retries and 2 dead letters", snapshot)
+	}
+	client.Flush()
+	if snapshot := client.Snapshot(); snapshot["retries"] != 4 || snapshot["deadletter"] != 3 {
+		t.Errorf("Snapshot() = %v, want 4 retries and 3 dead letters", snapshot)
+	}
+}
+
+func TestGaugeBool(t *testing.T) {
+	client, sink := newTestClient()
+	client.GaugeBool("foo", true)
+	client.GaugeBool("foo", false)
+	expectLines(t, sink, "foo:1|g", "foo:0|g")
+}
+
+func TestNewUnixStream(t *testing.T) {
+	dir, err := os.MkdirTemp("", "statsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/statsd.sock"
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	client := NewUnixStream(path, WithBuffering(2))
+	client.Increment("foo")
+	client.Gauge("bar", 2)
+	client.Timing("baz", 5)
+	client.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{"foo:1|c", "bar:2|g", "baz:5|ms"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("read %q, want %q", lines, want)
+	}
+}
+
+func TestWithDNSPolicyRetries(t *testing.T) {
+	captureLog(t)
+	d := &fakeDialer{err: &net.DNSError{Err: "no such host", Name: "statsd.local"}, fails: 2}
+	client := New("statsd.local", 8125, withDialer(d),
+		WithDNSPolicy(DNSPolicy{Retries: 3, Backoff: time.Millisecond}))
+	defer client.Close()
+	if len(d.addrs) != 3 || len(d.dialed()) != 1 {
+		t.Fatalf("dialed %q, want 2 failures and a success", d.addrs)
+	}
+	client.Increment("foo")
+	expectLines(t, &d.dialed()[0].sink, "foo:1|c")
+}
+
+func TestWithDNSPolicyFallback(t *testing.T) {
+	captureLog(t)
+	d := &fakeDialer{err: &net.DNSError{Err: "no such host", Name: "statsd.local"}, fails: 2}
+	client := New("statsd.local", 8125, withDialer(d),
+		WithDNSPolicy(DNSPolicy{Retries: 1, Backoff: time.Millisecond, FallbackIP: "10.0.0.5"}))
+	defer client.Close()
+	want := []string{"statsd.local:8125", "statsd.local:8125", "10.0.0.5:8125"}
+	if !reflect.DeepEqual(d.addrs, want) {
+		t.Fatalf("dialed %q, want %q", d.addrs, want)
+	}
+}
+
+func TestDNSRetriesDoNotBlockOtherWrites(t *testing.T) {
+	captureLog(t)
+	d := &fakeDialer{err: &net.DNSError{Err: "no such host", Name: "statsd.local"}}
+	client := New("statsd.local", 8125, withDialer(d))
+	defer client.Close()
+	client.dns = DNSPolicy{Retries: 1, Backoff: 200 * time.Millisecond}
+	d.mu.Lock()
+	d.fails = 1
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Increment("first")
+	}()
+	eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.addrs) == 2
+	})
+	start := time.Now()
+	client.Increment("second")
+	client.GaugeWithDelta("third", 1)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("writes waited %v for another write's DNS retry", elapsed)
+	}
+	<-done
+	client.Increment("fourth")
+	expectLines(t, &d.dialed()[0].sink, "first:1|c", "fourth:1|c")
+	if snapshot := client.Snapshot(); snapshot["dropped"] != 2 {
+		t.Errorf("Snapshot() = %v, want the 2 writes without a connection dropped", snapshot)
+	}
+}
+
+func TestConcurrentClose(t *testing.T) {
+	d := &fakeDialer{}
+	client := New("localhost", 8125, withDialer(d), WithConnections(2),
+		WithBestEffort(10), WithBuffering(100))
+	client.Increment("foo")
+	var wg sync.WaitGroup
+	dropped := make([]int, 8)
+	for i := range dropped {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				client.Close()
+				return
+			}
+			dropped[i] = client.Shutdown(time.Second)
+		}(i)
+	}
+	wg.Wait()
+	client.Close()
+	if n := client.Shutdown(time.Second); n != 0 {
+		t.Errorf("Shutdown after Close = %d, want 0", n)
+	}
+	var lines []string
+	for _, conn := range d.dialed() {
+		if n := atomic.LoadInt32(&conn.closed); n != 1 {
+			t.Errorf("connection closed %d times, want once", n)
+		}
+		lines = append(lines, conn.sink.Lines()...)
+	}
+	if !reflect.DeepEqual(lines, []string{"foo:1|c"}) {
+		t.Errorf("lines = %q, want the buffer flushed once", lines)
+	}
+	for i, n := range dropped {
+		if n != 0 {
+			t.Errorf("Shutdown %d reported %d dropped, want 0", i, n)
+		}
+	}
+}
+
+func TestCloseStopsReconnecting(t *testing.T) {
+	captureLog(t)
+	d := &fakeDialer{err: &net.DNSError{Err: "no such host", Name: "statsd.local"}}
+	client := New("statsd.local", 8125, withDialer(d), WithConnections(2))
+	client.dns = DNSPolicy{Retries: 1, Backoff: 50 * time.Millisecond}
+	d.mu.Lock()
+	d.fails = 1
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Increment("foo")
+	}()
+	eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.addrs) == 2
+	})
+	client.Close()
+	<-done
+	client.Increment("bar")
+	conns := d.dialed()
+	if len(conns) != 2 {
+		t.Fatalf("%d connections dialed, want the ring dialed once", len(conns))
+	}
+	for _, conn := range conns {
+		if !conn.isClosed() {
+			t.Error("connection dialed during Close was leaked")
+		}
+		expectLines(t, &conn.sink)
+	}
+}
@@ -0,0 +1,66 @@
+// Package statsdtest provides a sink and assertions for testing code that
+// emits metrics through a statsd client.
+package statsdtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/heatxsink/statsd-go"
+)
+
+/**
+ * A statsd.MemorySink bound to a test: the lines it recorded are logged
+ * when the test fails, so a failing assertion shows what was emitted
+ * Usage:
+ *
+ * import "statsd"
+ * import "statsd/statsdtest"
+ * sink := statsdtest.NewSink(t)
+ * client := statsd.NewWithWriter(sink)
+ * client.Increment("foo")
+ * sink.AssertEmitted(t, "foo", "1|c")
+ **/
+type Sink struct {
+	statsd.MemorySink
+}
+
+/**
+ * Returns an empty sink bound to t
+ **/
+func NewSink(t testing.TB) *Sink {
+	sink := &Sink{}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("statsd lines emitted: %q", sink.Lines())
+		}
+	})
+	return sink
+}
+
+/**
+ * Fails t unless stat was emitted with value, the part of the line after
+ * the name up to the sample rate and tags ("1|c", "12|ms", ...). Returns
+ * whether it was
+ **/
+func (sink *Sink) AssertEmitted(t testing.TB, stat string, value string) bool {
+	t.Helper()
+	if sink.Emitted(stat, value) {
+		return true
+	}
+	t.Errorf("statsd: %s:%s not emitted", stat, value)
+	return false
+}
+
+/**
+ * Reports whether stat was emitted with value, as AssertEmitted checks
+ **/
+func (sink *Sink) Emitted(stat string, value string) bool {
+	for _, line := range sink.Lines() {
+		rest, ok := strings.CutPrefix(line, stat+":")
+		if ok && (rest == value || strings.HasPrefix(rest, value+"|@") || strings.HasPrefix(rest, value+"|#")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package statsdtest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/heatxsink/statsd-go"
+)
+
+// fakeTB records what a test helper reports instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	errors   []string
+	logs     []string
+	cleanups []func()
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Logf(format string, args ...interface{}) {
+	tb.logs = append(tb.logs, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Cleanup(fn func()) { tb.cleanups = append(tb.cleanups, fn) }
+func (tb *fakeTB) Failed() bool      { return len(tb.errors) > 0 }
+
+// finish runs the cleanups like the end of a test does.
+func (tb *fakeTB) finish() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestAssertEmittedPasses(t *testing.T) {
+	tb := &fakeTB{}
+	sink := NewSink(tb)
+	client := statsd.NewWithWriter(sink, statsd.WithTags("env:prod"))
+	client.Increment("foo")
+	client.CountWithRate("bar", 5, 0.5)
+	if !sink.AssertEmitted(tb, "foo", "1|c") || !sink.AssertEmitted(tb, "bar", "5|c") {
+		t.Errorf("AssertEmitted failed on emitted lines: %q", tb.errors)
+	}
+	tb.finish()
+	if len(tb.errors) != 0 || len(tb.logs) != 0 {
+		t.Errorf("passing assertions reported %q and logged %q", tb.errors, tb.logs)
+	}
+}
+
+func TestAssertEmittedFails(t *testing.T) {
+	tb := &fakeTB{}
+	sink := NewSink(tb)
+	client := statsd.NewWithWriter(sink)
+	client.Increment("foo")
+	client.Timing("foobar", 12)
+	for _, tt := range []struct{ stat, value string }{
+		{"foo", "2|c"},
+		{"foo", "1"},
+		{"fo", "1|c"},
+		{"foobar", "1|ms"},
+		{"missing", "1|c"},
+	} {
+		if sink.AssertEmitted(tb, tt.stat, tt.value) {
+			t.Errorf("AssertEmitted(%q, %q) passed", tt.stat, tt.value)
+		}
+	}
+	if len(tb.errors) != 5 || tb.errors[0] != "statsd: foo:2|c not emitted" {
+		t.Errorf("errors = %q, want one per failed assertion", tb.errors)
+	}
+	tb.finish()
+	if len(tb.logs) != 1 || !strings.Contains(tb.logs[0], `"foo:1|c" "foobar:12|ms"`) {
+		t.Errorf("logs = %q, want the emitted lines once the test failed", tb.logs)
+	}
+}
@@ -0,0 +1,67 @@
+package statsd
+
+import "strings"
+
+/**
+ * Sets the value used by Template for placeholders missing from vars
+ * (default "unknown")
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithTemplateDefault("none"))
+ **/
+func WithTemplateDefault(value string) Option {
+	return func(client *StatsdClient) {
+		client.templateDefault = value
+	}
+}
+
+/**
+ * Builds a metric name from template, replacing every "{name}" placeholder
+ * with vars[name] sanitized like a Name component, or with the
+ * WithTemplateDefault value when vars has no such entry. The rest of the
+ * template is kept as is
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.Template("api.{method}.{status}", map[string]string{"method": "GET", "status": "200"}) // api.GET.200
+ **/
+func (client *StatsdClient) Template(template string, vars map[string]string) string {
+	var name strings.Builder
+	for {
+		before, rest, ok := strings.Cut(template, "{")
+		if !ok {
+			break
+		}
+		placeholder, after, ok := strings.Cut(rest, "}")
+		if !ok {
+			break
+		}
+		value, ok := vars[placeholder]
+		if !ok {
+			value = client.templateDefault
+			if value == "" {
+				value = "unknown"
+			}
+		}
+		name.WriteString(before)
+		name.WriteString(client.Name(value))
+		template = after
+	}
+	name.WriteString(template)
+	return name.String()
+}
+
+/**
+ * Sends value ("1|c", "12|ms", ...) to the stat named by filling template
+ * with vars, see Template
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125)
+ * client.EmitTemplate("api.{method}.{status}", map[string]string{"method": "GET", "status": "200"}, "1|c", 1)
+ **/
+func (client *StatsdClient) EmitTemplate(template string, vars map[string]string, value string, sampleRate float32) {
+	client.Send(map[string]string{client.Template(template, vars): value}, sampleRate)
+}
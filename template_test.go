@@ -0,0 +1,29 @@
+package statsd
+
+import "testing"
+
+func TestTemplate(t *testing.T) {
+	client, _ := newTestClient()
+	tests := []struct {
+		template string
+		vars     map[string]string
+		want     string
+	}{
+		{"api.{method}.{status}", map[string]string{"method": "GET", "status": "200"}, "api.GET.200"},
+		{"api.{method}.{status}", map[string]string{"method": "GET"}, "api.GET.unknown"},
+		{"api.{path}", map[string]string{"path": "v1.users:list"}, "api.v1_users_list"},
+		{"api.{method", map[string]string{"method": "GET"}, "api.{method"},
+		{"api.requests", nil, "api.requests"},
+	}
+	for _, test := range tests {
+		if got := client.Template(test.template, test.vars); got != test.want {
+			t.Errorf("Template(%q, %v) = %q, want %q", test.template, test.vars, got, test.want)
+		}
+	}
+}
+
+func TestEmitTemplate(t *testing.T) {
+	client, sink := newTestClient(WithTemplateDefault("none"))
+	client.EmitTemplate("api.{method}.{status}", map[string]string{"method": "POST"}, "1|c", 1)
+	expectLines(t, sink, "api.POST.none:1|c")
+}
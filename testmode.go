@@ -0,0 +1,85 @@
+package statsd
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// TestModeEnv is the environment variable that, when set to a non-empty
+// value, makes New, NewLazy, NewWithPacketConn and NewUnixStream write to
+// TestSink instead of the network, with WithShadow disabled.
+const TestModeEnv = "STATSD_TEST_MODE"
+
+/**
+ * An in-memory sink recording every update written to it, one entry per
+ * line. Safe for concurrent use
+ * Usage:
+ *
+ * import "statsd"
+ * sink := &statsd.MemorySink{}
+ * client := statsd.NewWithWriter(sink)
+ * client.Increment("foo")
+ * sink.Lines() // ["foo:1|c"]
+ **/
+type MemorySink struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (sink *MemorySink) Write(p []byte) (int, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.lines = append(sink.lines, strings.Split(string(p), "\n")...)
+	return len(p), nil
+}
+
+/**
+ * Returns a copy of the lines recorded so far
+ **/
+func (sink *MemorySink) Lines() []string {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return append([]string(nil), sink.lines...)
+}
+
+/**
+ * Forgets the lines recorded so far
+ **/
+func (sink *MemorySink) Reset() {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	sink.lines = nil
+}
+
+var testSink = &MemorySink{}
+
+/**
+ * Returns the sink that receives every update of clients created while
+ * STATSD_TEST_MODE is set, so tests of code that creates its own client
+ * can inspect what it emitted without anything being sent over UDP
+ * Usage:
+ *
+ *     // in a test, before the code under test creates its client
+ *     t.Setenv("STATSD_TEST_MODE", "1")
+ *     statsd.TestSink().Reset()
+ *     runCodeUnderTest()
+ *     lines := statsd.TestSink().Lines()
+ **/
+func TestSink() *MemorySink {
+	return testSink
+}
+
+// testMode points the client at TestSink, and drops its shadow, when
+// STATSD_TEST_MODE is set and reports whether it did.
+func (client *StatsdClient) testMode() bool {
+	if os.Getenv(TestModeEnv) == "" {
+		return false
+	}
+	client.writer = testSink
+	if client.shadow != nil {
+		client.shadow.close()
+		client.shadow = nil
+	}
+	return true
+}
@@ -0,0 +1,27 @@
+package statsd
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTestMode(t *testing.T) {
+	t.Setenv(TestModeEnv, "1")
+	TestSink().Reset()
+	target, shadow := listen(t), listen(t)
+	client := New("127.0.0.1", target.LocalAddr().(*net.UDPAddr).Port,
+		WithShadow("127.0.0.1", shadow.LocalAddr().(*net.UDPAddr).Port))
+	unix := NewUnixStream("/nonexistent/statsd.sock")
+	client.Increment("foo")
+	unix.Gauge("bar", 1)
+	client.Close()
+	unix.Close()
+	expectLines(t, TestSink(), "foo:1|c", "bar:1|g")
+	for _, pc := range []net.PacketConn{target, shadow} {
+		pc.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		if n, _, err := pc.ReadFrom(make([]byte, 1024)); err == nil {
+			t.Errorf("%d bytes sent over UDP in test mode", n)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package statsd
+
+import (
+	"math"
+	"strconv"
+	"sync"
+)
+
+// maxUpscaleCarries bounds the number of stats UpscaleCarry remembers a
+// remainder for; further stats are rounded.
+const maxUpscaleCarries = 10000
+
+/**
+ * How WithClientUpscale turns the fractional value/rate of a sampled
+ * counter into an integer
+ **/
+type UpscaleMode int
+
+const (
+	UpscaleRound UpscaleMode = iota // round half away from zero
+	UpscaleFloor                    // round down, undercounting by up to 1 per update
+	UpscaleCarry                    // round down and carry the remainder to the next update of the stat
+)
+
+// upscaler holds the remainders carried by UpscaleCarry.
+type upscaler struct {
+	mode  UpscaleMode
+	mu    sync.Mutex
+	carry map[string]float64
+}
+
+/**
+ * Upscales sampled counters on the client: a counter kept at rate r is
+ * sent as value/r, without the "|@r" suffix, for daemons that ignore
+ * sample rates. mode sets how the fraction is handled; UpscaleCarry keeps
+ * long-run totals exact
+ * Usage:
+ *
+ * import "statsd"
+ * client := statsd.New('localhost', 8125, statsd.WithClientUpscale(statsd.UpscaleCarry))
+ * client.IncrementWithSampling("foo", 0.3) // foo:3|c, foo:3|c, foo:4|c, ... when kept
+ **/
+func WithClientUpscale(mode UpscaleMode) Option {
+	return func(client *StatsdClient) {
+		client.upscaler = &upscaler{mode: mode, carry: make(map[string]float64)}
+	}
+}
+
+// scale returns the integer value a counter update of stat kept at rate
+// is sent as.
+func (u *upscaler) scale(stat string, value int, rate float32) int {
+	scaled := float64(value) / float64(rate)
+	switch u.mode {
+	case UpscaleFloor:
+		return int(math.Floor(scaled))
+	case UpscaleCarry:
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		carry, seen := u.carry[stat]
+		if !seen && len(u.carry) >= maxUpscaleCarries {
+			return int(math.Round(scaled))
+		}
+		whole := math.Floor(scaled + carry)
+		u.carry[stat] = scaled + carry - whole
+		return int(whole)
+	default:
+		return int(math.Round(scaled))
+	}
+}
+
+// upscale renders a kept counter update of m at rate with its value
+// upscaled, or reports false if client side upscaling does not apply.
+func (client *StatsdClient) upscale(m Metric, rate float32) (string, bool) {
+	if client.upscaler == nil || m.Type != "c" {
+		return "", false
+	}
+	value, err := strconv.Atoi(m.Value)
+	if err != nil {
+		return "", false
+	}
+	v := strconv.Itoa(client.upscaler.scale(m.Name, value, rate)) + "|c"
+	if m.fields != "" {
+		v += "|" + m.fields
+	}
+	return v, true
+}
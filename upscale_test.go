@@ -0,0 +1,48 @@
+package statsd
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// upscaledTotal sends n increments of foo at rate and sums the values sent.
+func upscaledTotal(t *testing.T, mode UpscaleMode, n int, rate float32) (total, kept int) {
+	client, sink := newTestClient(WithSeed(42), WithClientUpscale(mode))
+	for i := 0; i < n; i++ {
+		client.IncrementWithSampling("foo", rate)
+	}
+	for _, line := range sink.Lines() {
+		value, ok := strings.CutSuffix(strings.TrimPrefix(line, "foo:"), "|c")
+		if !ok {
+			t.Fatalf("sent %q, want an upscaled counter without a rate", line)
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += v
+		kept++
+	}
+	return total, kept
+}
+
+func TestWithClientUpscaleCarry(t *testing.T) {
+	total, kept := upscaledTotal(t, UpscaleCarry, 10000, 0.3)
+	if want := float64(kept) / 0.3; math.Abs(float64(total)-want) > 1 {
+		t.Errorf("total = %d for %d kept updates, want %.0f", total, kept, want)
+	}
+	if math.Abs(float64(total)-10000) > 500 {
+		t.Errorf("total = %d, want about 10000", total)
+	}
+}
+
+func TestWithClientUpscaleFloorAndRound(t *testing.T) {
+	for _, mode := range []UpscaleMode{UpscaleFloor, UpscaleRound} {
+		total, kept := upscaledTotal(t, mode, 1000, 0.3)
+		if total != 3*kept {
+			t.Errorf("mode %d: total = %d for %d kept updates, want %d", mode, total, kept, 3*kept)
+		}
+	}
+}